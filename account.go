@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/peanut996/CloudflareWarpSpeedTest/i18n"
+	"github.com/peanut996/CloudflareWarpSpeedTest/task"
+	"github.com/peanut996/CloudflareWarpSpeedTest/warp"
+)
+
+var (
+	// accountFile is the -account option; scans only load an account when
+	// it is set.
+	accountFile string
+
+	// account is the account loaded for a scan, nil without -account.
+	account *warp.Account
+
+	// forceRegister lets register replace an existing account file.
+	forceRegister bool
+)
+
+func accountPath() string {
+	if accountFile == "" {
+		return warp.DefaultAccountFile
+	}
+	return accountFile
+}
+
+// runRegister creates a WARP account and saves it for later scans. An
+// existing account file is only replaced with -force, as its device can
+// no longer be managed or deleted afterwards.
+func runRegister(ctx context.Context) {
+	// fail before registering a device that could not be saved
+	if _, err := os.Stat(accountPath()); err == nil && !forceRegister {
+		log.Fatalln(i18n.QueryI18n(i18n.AccountExists) + accountPath())
+	}
+	acc, err := warp.Register(ctx)
+	if err != nil {
+		if acc != nil {
+			createAccount(acc)
+			log.Println(i18n.QueryTemplateI18n(i18n.RegisterIncomplete, map[string]interface{}{"Path": accountPath()}))
+		}
+		log.Fatalln(i18n.QueryI18n(i18n.RegisterFailed) + err.Error())
+	}
+	createAccount(acc)
+	fmt.Printf("%s%s\n", i18n.QueryI18n(i18n.AccountSaved), accountPath())
+}
+
+// createAccount saves a newly registered acc, replacing an existing
+// account file only with -force.
+func createAccount(acc *warp.Account) {
+	save := acc.Create
+	if forceRegister {
+		save = acc.Save
+	}
+	if err := save(accountPath()); err != nil {
+		log.Fatalln(i18n.QueryI18n(i18n.AccountSaveFailed) + err.Error())
+	}
+}
+
+// saveAccount writes acc to the account file, exiting if that fails.
+func saveAccount(acc *warp.Account) {
+	if err := acc.Save(accountPath()); err != nil {
+		log.Fatalln(i18n.QueryI18n(i18n.AccountSaveFailed) + err.Error())
+	}
+}
+
+// loadAccount fills the key and reserved options left unset from the
+// -account file.
+func loadAccount() {
+	if accountFile == "" {
+		return
+	}
+	acc, err := warp.LoadAccount(accountFile)
+	if err != nil {
+		log.Fatalln(i18n.QueryI18n(i18n.AccountLoadFailed) + err.Error())
+	}
+	account = acc
+	if task.PrivateKey == "" {
+		task.PrivateKey = acc.PrivateKey
+	}
+	if task.PublicKey == "" {
+		task.PublicKey = acc.PeerPublicKey
+	}
+	if task.ReservedString == "" {
+		task.ReservedString = acc.ClientID
+	}
+	// the peer only routes the address it assigned to the account
+	if acc.IPv4 != "" && !flagGiven("tunaddr") {
+		task.TunnelAddress = acc.IPv4
+	}
+}
+
+// flagGiven reports whether the flag name was set on the command line.
+func flagGiven(name string) bool {
+	given := false
+	flag.Visit(func(f *flag.Flag) {
+		given = given || f.Name == name
+	})
+	return given
+}
+
+// runAccount runs one of the account subcommands on the -account file.
+func runAccount(ctx context.Context, args []string) {
+	action := "show"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	// arg returns the value the action is given, exiting if it is missing
+	arg := func() string {
+		if len(args) < 2 || args[1] == "" {
+			log.Fatalln(i18n.QueryI18n(i18n.ArgumentMissing) + "account " + action)
+		}
+		return args[1]
+	}
+
+	acc, err := warp.LoadAccount(accountPath())
+	if err != nil {
+		log.Fatalln(i18n.QueryI18n(i18n.AccountLoadFailed) + err.Error())
+	}
+	privateKey := acc.PrivateKey
+	switch action {
+	case "show":
+		err = acc.Refresh(ctx)
+	case "license":
+		err = acc.ApplyLicense(ctx, arg())
+	case "rename":
+		err = acc.Rename(ctx, arg())
+	case "rotate-key":
+		err = acc.RotateKey(ctx)
+	case "delete":
+		if err := acc.Delete(ctx); err != nil {
+			log.Fatalln(i18n.QueryI18n(i18n.AccountRequestFailed) + err.Error())
+		}
+		if err := os.Remove(accountPath()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Fatalln(i18n.QueryI18n(i18n.AccountRemoveFailed) + err.Error())
+		}
+		fmt.Println(i18n.QueryI18n(i18n.AccountDeleted) + accountPath())
+		return
+	default:
+		fmt.Fprintln(os.Stderr, i18n.QueryI18n(i18n.UnknownCommand)+"account "+action)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		// once rotated, the new private key is the only one the API accepts,
+		// even if refreshing the account afterwards failed
+		if acc.PrivateKey != privateKey {
+			saveAccount(acc)
+		}
+		log.Fatalln(i18n.QueryI18n(i18n.AccountRequestFailed) + err.Error())
+	}
+	saveAccount(acc)
+	quota, err := acc.Quota(ctx)
+	if err != nil {
+		log.Fatalln(i18n.QueryI18n(i18n.AccountRequestFailed) + err.Error())
+	}
+	printAccount(acc, quota)
+}
+
+func printAccount(acc *warp.Account, quota *warp.Quota) {
+	line := func(label, value string) {
+		if value != "" {
+			fmt.Printf("%s %s\n", i18n.QueryI18n(label), value)
+		}
+	}
+	line(i18n.AccountDeviceID, acc.ID)
+	line(i18n.AccountDeviceName, acc.Name)
+	line(i18n.AccountTypeLabel, accountTypeName(quota.AccountType))
+	line(i18n.AccountLicense, acc.License)
+	line(i18n.AccountQuota, quotaText(quota))
+	line(i18n.AccountAddresses, strings.TrimSuffix(acc.IPv4+", "+acc.IPv6, ", "))
+	line(i18n.AccountPeerKey, acc.PeerPublicKey)
+	line(i18n.AccountEndpoint, acc.Endpoint)
+	line(i18n.AccountReserved, fmt.Sprintf("%s %v", acc.ClientID, acc.Reserved))
+}
+
+func accountTypeName(accountType string) string {
+	switch accountType {
+	case "free":
+		return i18n.QueryI18n(i18n.AccountTypeFree)
+	case "limited":
+		return i18n.QueryI18n(i18n.AccountTypeLimited)
+	case "unlimited":
+		return i18n.QueryI18n(i18n.AccountTypeUnlimited)
+	case "team":
+		return i18n.QueryI18n(i18n.AccountTypeTeam)
+	}
+	return accountType
+}
+
+func quotaText(quota *warp.Quota) string {
+	switch {
+	case quota.AccountType == "unlimited" || quota.AccountType == "team":
+		return i18n.QueryI18n(i18n.AccountQuotaUnlimited)
+	case quota.Quota <= 0:
+		return i18n.QueryI18n(i18n.AccountQuotaNone)
+	}
+	return i18n.QueryTemplateI18n(i18n.AccountQuotaValue, map[string]interface{}{
+		"Left":  formatBytes(quota.PremiumData),
+		"Total": formatBytes(quota.Quota),
+	})
+}
+
+// formatBytes formats n in decimal units, as the WARP clients show quotas.
+func formatBytes(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB", "PB"}
+	v, i := float64(n), 0
+	for v >= 1000 && i < len(units)-1 {
+		v /= 1000
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.2f %s", v, units[i])
+}
@@ -0,0 +1,317 @@
+// Package emulator runs local WARP-like WireGuard endpoints on loopback, so
+// that the scanner can be exercised without reaching Cloudflare.
+package emulator
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	mrand "math/rand/v2"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+const defaultHost = "127.0.0.1"
+
+// Profile describes how one emulated endpoint behaves.
+type Profile struct {
+	// Port to listen on; 0 picks a free port.
+	Port int
+	// Latency delays every reply, Jitter varies the delay uniformly by up to
+	// this much in either direction.
+	Latency time.Duration
+	Jitter  time.Duration
+	// Loss is the probability that an initiation goes unanswered.
+	Loss float64
+	// CookieReply makes the endpoint act as under load and answer
+	// initiations without a valid MAC2 with a cookie reply.
+	CookieReply bool
+	// Garbage answers every initiation with random bytes.
+	Garbage bool
+	// WrongSize answers with a truncated handshake response.
+	WrongSize bool
+	// WrongReceiver answers with a handshake response to another initiation.
+	WrongReceiver bool
+}
+
+// Obfuscation is the AmneziaWG framing the endpoints expect. The zero value
+// is vanilla WireGuard.
+type Obfuscation struct {
+	InitJunkSize     int
+	ResponseJunkSize int
+	InitHeader       uint32
+	ResponseHeader   uint32
+	CookieHeader     uint32
+}
+
+func (o Obfuscation) custom() bool {
+	return o.InitHeader != device.MessageInitiationType ||
+		o.ResponseHeader != device.MessageResponseType ||
+		o.CookieHeader != device.MessageCookieReplyType
+}
+
+func (o *Obfuscation) setDefaults() {
+	if o.InitHeader == 0 {
+		o.InitHeader = device.MessageInitiationType
+	}
+	if o.ResponseHeader == 0 {
+		o.ResponseHeader = device.MessageResponseType
+	}
+	if o.CookieHeader == 0 {
+		o.CookieHeader = device.MessageCookieReplyType
+	}
+}
+
+// Config configures an Emulator.
+type Config struct {
+	// Host is the address to listen on, 127.0.0.1 by default.
+	Host string
+	// PrivateKey is the static key shared by all endpoints. Initiations
+	// made for it get a complete Noise IK response.
+	PrivateKey device.NoisePrivateKey
+	// ForeignKeys are public keys of peers the endpoints impersonate, such
+	// as the WARP key of the built-in packet. Initiations made for them
+	// pass the MAC1 check but only get a correctly framed response, since
+	// their private keys are unknown.
+	ForeignKeys []device.NoisePublicKey
+	Obfuscation Obfuscation
+	// Seed makes loss and jitter reproducible.
+	Seed      uint64
+	Endpoints []Profile
+}
+
+// Emulator is a set of running endpoints.
+type Emulator struct {
+	identities  []*identity
+	obfuscation Obfuscation
+	endpoints   []*endpoint
+	wg          sync.WaitGroup
+}
+
+// identity is a key the endpoints answer for.
+type identity struct {
+	checker device.CookieChecker
+	// responder is nil for foreign keys
+	responder *responder
+}
+
+type endpoint struct {
+	em      *Emulator
+	profile Profile
+	conn    *net.UDPConn
+
+	m   sync.Mutex
+	rng *mrand.Rand
+}
+
+// Start listens on every configured endpoint and answers initiations until
+// Close is called.
+func Start(cfg Config) (*Emulator, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, errors.New("no endpoints configured")
+	}
+	host := cfg.Host
+	if host == "" {
+		host = defaultHost
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newResponder(cfg.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	em := &Emulator{obfuscation: cfg.Obfuscation}
+	em.obfuscation.setDefaults()
+	own := &identity{responder: r}
+	own.checker.Init(r.staticPub)
+	em.identities = append(em.identities, own)
+	for _, pub := range cfg.ForeignKeys {
+		foreign := &identity{}
+		foreign.checker.Init(pub)
+		em.identities = append(em.identities, foreign)
+	}
+
+	for i, profile := range cfg.Endpoints {
+		conn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(profile.Port))))
+		if err != nil {
+			em.Close()
+			return nil, err
+		}
+		e := &endpoint{
+			em:      em,
+			profile: profile,
+			conn:    conn,
+			rng:     mrand.New(mrand.NewPCG(cfg.Seed, uint64(i))),
+		}
+		em.endpoints = append(em.endpoints, e)
+		em.wg.Add(1)
+		go e.serve()
+	}
+	return em, nil
+}
+
+// Addrs returns the endpoint addresses in configuration order.
+func (em *Emulator) Addrs() []netip.AddrPort {
+	addrs := make([]netip.AddrPort, len(em.endpoints))
+	for i, e := range em.endpoints {
+		addrs[i] = e.conn.LocalAddr().(*net.UDPAddr).AddrPort()
+	}
+	return addrs
+}
+
+// PublicKey returns the public key of the endpoints' static key.
+func (em *Emulator) PublicKey() device.NoisePublicKey {
+	return em.identities[0].responder.staticPub
+}
+
+func (em *Emulator) Close() {
+	for _, e := range em.endpoints {
+		e.conn.Close()
+	}
+	em.wg.Wait()
+}
+
+func (e *endpoint) serve() {
+	defer e.em.wg.Done()
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := e.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		reply := e.reply(buf[:n], from)
+		if reply == nil {
+			continue
+		}
+		delay, lost := e.fate()
+		if lost {
+			continue
+		}
+		time.AfterFunc(delay, func() {
+			e.conn.WriteToUDPAddrPort(reply, from)
+		})
+	}
+}
+
+// fate decides the delay of a reply and whether it is lost.
+func (e *endpoint) fate() (time.Duration, bool) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if e.profile.Loss > 0 && e.rng.Float64() < e.profile.Loss {
+		return 0, true
+	}
+	delay := e.profile.Latency
+	if e.profile.Jitter > 0 {
+		delay += time.Duration(e.rng.Int64N(int64(2*e.profile.Jitter)+1)) - e.profile.Jitter
+	}
+	return max(delay, 0), false
+}
+
+// reply returns the answer to packet, or nil if it is not an initiation
+// for one of the emulator's keys.
+func (e *endpoint) reply(packet []byte, from netip.AddrPort) []byte {
+	obf := e.em.obfuscation
+	if len(packet) != obf.InitJunkSize+device.MessageInitiationSize {
+		return nil
+	}
+	msg := bytes.Clone(packet[obf.InitJunkSize:])
+	if !hasHeader(msg, obf.InitHeader, obf.custom()) {
+		return nil
+	}
+	if e.profile.Garbage {
+		return garbage()
+	}
+	if !obf.custom() {
+		// WARP leaves the reserved bytes out of the MACs
+		msg[1], msg[2], msg[3] = 0, 0, 0
+	}
+
+	var id *identity
+	for _, candidate := range e.em.identities {
+		if candidate.checker.CheckMAC1(msg) {
+			id = candidate
+			break
+		}
+	}
+	if id == nil {
+		return nil
+	}
+	var init device.MessageInitiation
+	if err := binary.Read(bytes.NewReader(msg), binary.LittleEndian, &init); err != nil {
+		return nil
+	}
+
+	src, _ := from.MarshalBinary()
+	if e.profile.CookieReply && !id.checker.CheckMAC2(msg, src) {
+		reply, err := id.checker.CreateReply(msg, init.Sender, src)
+		if err != nil {
+			return nil
+		}
+		out := marshal(reply)
+		putHeader(out, obf.CookieHeader)
+		return out
+	}
+
+	var resp []byte
+	if id.responder != nil {
+		var peer device.NoisePublicKey
+		var err error
+		if resp, peer, err = id.responder.respond(&init); err != nil {
+			return nil
+		}
+		putHeader(resp, obf.ResponseHeader)
+		generator := device.CookieGenerator{}
+		generator.Init(peer)
+		generator.AddMacs(resp)
+	} else {
+		resp = frameResponse(&init)
+		putHeader(resp, obf.ResponseHeader)
+	}
+	if e.profile.WrongReceiver {
+		binary.LittleEndian.PutUint32(resp[8:12], ^init.Sender)
+	}
+	if e.profile.WrongSize {
+		resp = resp[:len(resp)-8]
+	}
+	return pad(resp, obf.ResponseJunkSize)
+}
+
+func hasHeader(msg []byte, header uint32, custom bool) bool {
+	if custom {
+		return binary.LittleEndian.Uint32(msg) == header
+	}
+	return msg[0] == byte(header)
+}
+
+func putHeader(msg []byte, header uint32) {
+	binary.LittleEndian.PutUint32(msg, header)
+}
+
+func pad(msg []byte, size int) []byte {
+	if size == 0 {
+		return msg
+	}
+	out := make([]byte, size+len(msg))
+	rand.Read(out[:size])
+	copy(out[size:], msg)
+	return out
+}
+
+// garbage returns random bytes that do not look like a WireGuard message.
+func garbage() []byte {
+	out := make([]byte, 16+mrand.IntN(64))
+	rand.Read(out)
+	out[0] = 0xff
+	return out
+}
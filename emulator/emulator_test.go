@@ -0,0 +1,142 @@
+package emulator
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+// warpInitiation is the scanner's built-in initiation, made for the WARP
+// public key below.
+const (
+	warpInitiation = "013cbdafb4135cac96a29484d7a0175ab152dd3e59be35049beadf758b8d48af14ca65f25a168934746fe8bc8867b1c17113d71c0fac5c141ef9f35783ffa5357c9871f4a006662b83ad71245a862495376a5fe3b4f2e1f06974d748416670e5f9b086297f652e6dfbf742fbfc63c3d8aeb175a3e9b7582fbc67c77577e4c0b32b05f92900000000000000000000000000000000"
+	warpPublicKey  = "bmXOC+F1FxEMF9dyiK2H5/1SUtzH0JuVo51h2wPfgyo="
+)
+
+func startTestEmulator(t *testing.T, profiles ...Profile) *Emulator {
+	t.Helper()
+	var pub device.NoisePublicKey
+	b, _ := base64.StdEncoding.DecodeString(warpPublicKey)
+	copy(pub[:], b)
+	var pri device.NoisePrivateKey
+	pri[0] = 1
+	em, err := Start(Config{PrivateKey: pri, ForeignKeys: []device.NoisePublicKey{pub}, Seed: 1, Endpoints: profiles})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(em.Close)
+	return em
+}
+
+// send writes packet to addr and returns the reply, or nil after timeout.
+func send(t *testing.T, addr netip.AddrPort, packet []byte, timeout time.Duration) []byte {
+	t.Helper()
+	conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(packet); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 2048)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil
+	}
+	return buf[:n]
+}
+
+func TestEmulator_ForeignKey(t *testing.T) {
+	packet, _ := hex.DecodeString(warpInitiation)
+	sender := binary.LittleEndian.Uint32(packet[4:8])
+
+	tests := []struct {
+		name     string
+		profile  Profile
+		wantSize int
+		wantType byte
+	}{
+		{name: "response", profile: Profile{}, wantSize: device.MessageResponseSize, wantType: device.MessageResponseType},
+		{name: "cookie reply", profile: Profile{CookieReply: true}, wantSize: device.MessageCookieReplySize, wantType: device.MessageCookieReplyType},
+		{name: "wrong size", profile: Profile{WrongSize: true}, wantSize: device.MessageResponseSize - 8, wantType: device.MessageResponseType},
+		{name: "wrong receiver", profile: Profile{WrongReceiver: true}, wantSize: device.MessageResponseSize, wantType: device.MessageResponseType},
+		{name: "lost", profile: Profile{Loss: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			em := startTestEmulator(t, tt.profile)
+			reply := send(t, em.Addrs()[0], packet, 200*time.Millisecond)
+			if len(reply) != tt.wantSize {
+				t.Fatalf("reply size = %v, want %v", len(reply), tt.wantSize)
+			}
+			if reply == nil {
+				return
+			}
+			if reply[0] != tt.wantType {
+				t.Errorf("reply type = %v, want %v", reply[0], tt.wantType)
+			}
+			receiver := binary.LittleEndian.Uint32(reply[8:12])
+			if tt.wantType == device.MessageCookieReplyType {
+				receiver = binary.LittleEndian.Uint32(reply[4:8])
+			}
+			if (receiver == sender) == tt.profile.WrongReceiver {
+				t.Errorf("reply receiver = %v for sender %v, wrong receiver %v", receiver, sender, tt.profile.WrongReceiver)
+			}
+		})
+	}
+}
+
+func TestEmulator_UnknownKey(t *testing.T) {
+	packet, _ := hex.DecodeString(warpInitiation)
+	packet[len(packet)-20] ^= 0xff // break MAC1
+
+	em := startTestEmulator(t, Profile{})
+	if reply := send(t, em.Addrs()[0], packet, 200*time.Millisecond); reply != nil {
+		t.Errorf("emulator answered an initiation with an invalid MAC1: %x", reply)
+	}
+}
+
+func TestEmulator_Garbage(t *testing.T) {
+	packet, _ := hex.DecodeString(warpInitiation)
+
+	em := startTestEmulator(t, Profile{Garbage: true})
+	reply := send(t, em.Addrs()[0], packet, 200*time.Millisecond)
+	if reply == nil || reply[0] != 0xff {
+		t.Errorf("reply = %x, want random bytes", reply)
+	}
+}
+
+func TestEndpoint_Fate(t *testing.T) {
+	em := startTestEmulator(t,
+		Profile{Latency: 50 * time.Millisecond, Jitter: 10 * time.Millisecond},
+		Profile{Loss: 0.5},
+	)
+
+	jittery := em.endpoints[0]
+	for i := 0; i < 100; i++ {
+		delay, lost := jittery.fate()
+		if lost || delay < 40*time.Millisecond || delay > 60*time.Millisecond {
+			t.Fatalf("fate() = (%v, %v), want a delay of 50ms ± 10ms", delay, lost)
+		}
+	}
+
+	lossy := em.endpoints[1]
+	lostCount := 0
+	for i := 0; i < 1000; i++ {
+		if _, lost := lossy.fate(); lost {
+			lostCount++
+		}
+	}
+	if lostCount < 400 || lostCount > 600 {
+		t.Errorf("fate() lost %v of 1000 replies, want about 500", lostCount)
+	}
+}
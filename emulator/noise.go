@@ -0,0 +1,147 @@
+package emulator
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.zx2c4.com/wireguard/device"
+)
+
+var errInvalidInitiation = errors.New("invalid handshake initiation")
+
+// responder is the responder side of the WireGuard Noise IK handshake. It
+// accepts initiations from any peer.
+type responder struct {
+	static    *ecdh.PrivateKey
+	staticPub device.NoisePublicKey
+	// initialHash is the protocol hash already mixed with staticPub
+	initialHash [blake2s.Size]byte
+}
+
+func newResponder(pri device.NoisePrivateKey) (*responder, error) {
+	static, err := ecdh.X25519().NewPrivateKey(pri[:])
+	if err != nil {
+		return nil, err
+	}
+	r := &responder{static: static, initialHash: device.InitialHash}
+	copy(r.staticPub[:], static.PublicKey().Bytes())
+	mixHash(&r.initialHash, r.staticPub[:])
+	return r, nil
+}
+
+// respond consumes msg and returns the MessageResponse for it, without
+// header and MACs.
+func (r *responder) respond(msg *device.MessageInitiation) ([]byte, device.NoisePublicKey, error) {
+	var peer device.NoisePublicKey
+	hash := r.initialHash
+	chainKey := device.InitialChainKey
+
+	device.KDF1(&chainKey, chainKey[:], msg.Ephemeral[:])
+	mixHash(&hash, msg.Ephemeral[:])
+
+	peerEphemeral, err := ecdh.X25519().NewPublicKey(msg.Ephemeral[:])
+	if err != nil {
+		return nil, peer, errInvalidInitiation
+	}
+
+	// decrypt static key
+	ss, err := r.static.ECDH(peerEphemeral)
+	if err != nil {
+		return nil, peer, errInvalidInitiation
+	}
+	var key [chacha20poly1305.KeySize]byte
+	device.KDF2(&chainKey, &key, chainKey[:], ss)
+	aead, _ := chacha20poly1305.New(key[:])
+	if _, err := aead.Open(peer[:0], device.ZeroNonce[:], msg.Static[:], hash[:]); err != nil {
+		return nil, peer, errInvalidInitiation
+	}
+	mixHash(&hash, msg.Static[:])
+
+	// decrypt timestamp
+	peerStatic, err := ecdh.X25519().NewPublicKey(peer[:])
+	if err != nil {
+		return nil, peer, errInvalidInitiation
+	}
+	ss, err = r.static.ECDH(peerStatic)
+	if err != nil {
+		return nil, peer, errInvalidInitiation
+	}
+	device.KDF2(&chainKey, &key, chainKey[:], ss)
+	aead, _ = chacha20poly1305.New(key[:])
+	if _, err := aead.Open(nil, device.ZeroNonce[:], msg.Timestamp[:], hash[:]); err != nil {
+		return nil, peer, errInvalidInitiation
+	}
+	mixHash(&hash, msg.Timestamp[:])
+
+	// create response
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, peer, err
+	}
+	resp := device.MessageResponse{
+		Type:     device.MessageResponseType,
+		Sender:   randomIndex(),
+		Receiver: msg.Sender,
+	}
+	copy(resp.Ephemeral[:], ephemeral.PublicKey().Bytes())
+	mixHash(&hash, resp.Ephemeral[:])
+	device.KDF1(&chainKey, chainKey[:], resp.Ephemeral[:])
+
+	ss, err = ephemeral.ECDH(peerEphemeral)
+	if err != nil {
+		return nil, peer, errInvalidInitiation
+	}
+	device.KDF1(&chainKey, chainKey[:], ss)
+	ss, err = ephemeral.ECDH(peerStatic)
+	if err != nil {
+		return nil, peer, errInvalidInitiation
+	}
+	device.KDF1(&chainKey, chainKey[:], ss)
+
+	// WARP does not use a preshared key
+	var psk device.NoisePresharedKey
+	var tau [blake2s.Size]byte
+	device.KDF3(&chainKey, &tau, &key, chainKey[:], psk[:])
+	mixHash(&hash, tau[:])
+	aead, _ = chacha20poly1305.New(key[:])
+	aead.Seal(resp.Empty[:0], device.ZeroNonce[:], nil, hash[:])
+
+	return marshal(&resp), peer, nil
+}
+
+// frameResponse returns a MessageResponse that only has the right framing,
+// used to answer initiations made for a key the emulator does not own.
+func frameResponse(msg *device.MessageInitiation) []byte {
+	resp := device.MessageResponse{
+		Type:     device.MessageResponseType,
+		Sender:   randomIndex(),
+		Receiver: msg.Sender,
+	}
+	rand.Read(resp.Ephemeral[:])
+	rand.Read(resp.Empty[:])
+	return marshal(&resp)
+}
+
+func marshal(msg any) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, msg)
+	return buf.Bytes()
+}
+
+func randomIndex() uint32 {
+	var b [4]byte
+	rand.Read(b[:])
+	return binary.LittleEndian.Uint32(b[:])
+}
+
+func mixHash(h *[blake2s.Size]byte, data []byte) {
+	hash, _ := blake2s.New256(nil)
+	hash.Write(h[:])
+	hash.Write(data)
+	hash.Sum(h[:0])
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/peanut996/CloudflareWarpSpeedTest/export"
+	"github.com/peanut996/CloudflareWarpSpeedTest/i18n"
+	"github.com/peanut996/CloudflareWarpSpeedTest/task"
+	"github.com/peanut996/CloudflareWarpSpeedTest/utils"
+)
+
+var (
+	// wgConfigPath is where the wg-quick configuration is written; empty
+	// disables the export.
+	wgConfigPath string
+
+	// singBoxPath, clashPath and xrayPath are where the proxy core
+	// configurations are written; empty disables each.
+	singBoxPath string
+	clashPath   string
+	xrayPath    string
+
+	// exportCount best endpoints are exported: one wg-quick file each, and
+	// one outbound each in the proxy core configurations.
+	exportCount = 1
+
+	wgDNS = "1.1.1.1,2606:4700:4700::1111"
+
+	wgMTU = 1280
+)
+
+// wireGuardProfile returns the client profile for endpoint, built from the
+// key options and the loaded account.
+func wireGuardProfile(endpoint string) export.Profile {
+	p := export.Profile{
+		PrivateKey:    task.PrivateKey,
+		Addresses:     []string{withHostMask(task.TunnelAddress)},
+		DNS:           splitList(wgDNS),
+		MTU:           wgMTU,
+		PeerPublicKey: task.PublicKey,
+		Endpoint:      endpoint,
+	}
+	if account != nil && account.IPv6 != "" {
+		p.Addresses = append(p.Addresses, withHostMask(account.IPv6))
+	}
+	if p.PeerPublicKey == "" {
+		p.PeerPublicKey = task.WarpPublicKey
+	}
+	if task.ReservedString != "" {
+		// InitHandshakePacket has already rejected invalid values
+		reserved, _ := utils.ParseReservedString(task.ReservedString)
+		p.Reserved = reserved[:]
+	}
+	return p
+}
+
+// proxyConfigs are the proxy core formats, each holding all exported
+// endpoints in one file.
+var proxyConfigs = []struct {
+	path   *string
+	render func([]export.Profile) ([]byte, error)
+}{
+	{&singBoxPath, export.SingBox},
+	{&clashPath, export.ClashMeta},
+	{&xrayPath, export.Xray},
+}
+
+// exportConfigs writes the configurations asked for with the best
+// exportCount endpoints of a completed scan.
+func exportConfigs(ctx context.Context, ipSet utils.PingDelaySet) {
+	if wgConfigPath == "" && singBoxPath == "" && clashPath == "" && xrayPath == "" {
+		return
+	}
+	if ctx.Err() != nil {
+		fmt.Println(i18n.QueryI18n(i18n.ConfigExportSkipped))
+		return
+	}
+	if task.PrivateKey == "" {
+		log.Fatalln(i18n.QueryI18n(i18n.ConfigExportKeyRequired))
+	}
+	endpoints := task.BestEndpoints(ipSet, exportCount)
+	if len(endpoints) == 0 {
+		fmt.Println(i18n.QueryI18n(i18n.ConfigExportNoEndpoint))
+		return
+	}
+	profiles := make([]export.Profile, len(endpoints))
+	for i, endpoint := range endpoints {
+		profiles[i] = wireGuardProfile(endpoint.String())
+	}
+
+	if wgConfigPath != "" {
+		for i, p := range profiles {
+			writeConfig(numberedPath(wgConfigPath, i, len(profiles)), export.WGQuick(p))
+		}
+	}
+	for _, c := range proxyConfigs {
+		if *c.path == "" {
+			continue
+		}
+		data, err := c.render(profiles)
+		if err != nil {
+			log.Fatalln(i18n.QueryI18n(i18n.ConfigExportFailed) + err.Error())
+		}
+		writeConfig(*c.path, data)
+	}
+}
+
+// applyTo points the WARP peer of the configuration at path to the best
+// endpoint of a completed scan.
+func applyTo(ctx context.Context, path string, ipSet utils.PingDelaySet) {
+	if ctx.Err() != nil {
+		fmt.Println(i18n.QueryI18n(i18n.ApplySkipped))
+		return
+	}
+	endpoints := task.BestEndpoints(ipSet, 1)
+	if len(endpoints) == 0 {
+		fmt.Println(i18n.QueryI18n(i18n.ConfigExportNoEndpoint))
+		return
+	}
+	peerPublicKey := task.PublicKey
+	if peerPublicKey == "" {
+		peerPublicKey = task.WarpPublicKey
+	}
+	diff, err := export.ApplyToFile(path, peerPublicKey, endpoints[0].String())
+	if err != nil {
+		log.Fatalln(i18n.QueryI18n(i18n.ApplyFailed) + err.Error())
+	}
+	if diff == "" {
+		fmt.Println(i18n.QueryI18n(i18n.ApplyUnchanged) + path)
+		return
+	}
+	fmt.Print("\n" + diff)
+	fmt.Println(i18n.QueryTemplateI18n(i18n.ApplyDone, map[string]interface{}{"Path": path, "Backup": path + ".bak"}))
+}
+
+// writeConfig writes a configuration readable by the owner only, as it
+// holds the private key.
+func writeConfig(path string, data []byte) {
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		log.Fatalln(i18n.QueryI18n(i18n.ConfigExportFailed) + err.Error())
+	}
+	fmt.Println(i18n.QueryI18n(i18n.ConfigExportDone) + path)
+}
+
+// numberedPath returns path itself for a single file, and path with the
+// 1-based index before its extension otherwise: wg0-1.conf, wg0-2.conf.
+func numberedPath(path string, i, total int) string {
+	if total == 1 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), i+1, ext)
+}
+
+// withHostMask adds a host prefix length to a bare address.
+func withHostMask(addr string) string {
+	if strings.Contains(addr, "/") {
+		return addr
+	}
+	if strings.Contains(addr, ":") {
+		return addr + "/128"
+	}
+	return addr + "/32"
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,522 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	errPeerNotFound = errors.New("no peer with the given public key")
+	errInvalidJSON  = errors.New("invalid JSON")
+
+	iniSectionPattern = regexp.MustCompile(`(?m)^\s*\[(?i:interface|peer)\]`)
+)
+
+// ApplyToFile points the peers with the given public key in the wg-quick,
+// sing-box, Xray or Clash configuration at path to endpoint. The original
+// is kept as path.bak and the new file replaces it atomically. It returns
+// a diff of the change, empty when the peers already use endpoint.
+func ApplyToFile(path, peerPublicKey, endpoint string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	updated, err := Apply(data, peerPublicKey, endpoint)
+	if err != nil {
+		return "", err
+	}
+	if bytes.Equal(data, updated) {
+		return "", nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path+".bak", data, info.Mode().Perm()); err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(path, updated, info.Mode().Perm()); err != nil {
+		return "", err
+	}
+	return Diff(path, data, updated), nil
+}
+
+// Apply rewrites only the endpoint fields of the peers with the given
+// public key, leaving the rest of the configuration as it was.
+func Apply(data []byte, peerPublicKey, endpoint string) ([]byte, error) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	switch trimmed := bytes.TrimSpace(data); {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return applyJSON(data, peerPublicKey, endpoint, host, port)
+	case iniSectionPattern.Match(data):
+		return applyWGQuick(data, peerPublicKey, endpoint)
+	default:
+		return applyClash(data, peerPublicKey, host, port)
+	}
+}
+
+// writeFileAtomic replaces path with data through a temporary file in the
+// same directory, so that readers see either the old or the new file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// applyWGQuick sets Endpoint in every [Peer] section with the public key,
+// adding it after PublicKey when missing.
+func applyWGQuick(data []byte, peerPublicKey, endpoint string) ([]byte, error) {
+	lines := strings.Split(string(data), "\n")
+	type peerSection struct {
+		publicKey, endpoint int
+	}
+	var sections []peerSection
+	var current *peerSection
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			current = nil
+			if strings.EqualFold(trimmed, "[Peer]") {
+				sections = append(sections, peerSection{publicKey: -1, endpoint: -1})
+				current = &sections[len(sections)-1]
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(stripComment(trimmed), "=")
+		if current == nil || !ok {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "publickey":
+			if strings.TrimSpace(value) == peerPublicKey {
+				current.publicKey = i
+			}
+		case "endpoint":
+			current.endpoint = i
+		}
+	}
+
+	found := false
+	// later sections first, so that inserted lines do not shift the
+	// indexes still to be used
+	for i := len(sections) - 1; i >= 0; i-- {
+		s := sections[i]
+		if s.publicKey < 0 {
+			continue
+		}
+		found = true
+		if s.endpoint >= 0 {
+			lines[s.endpoint] = replaceINIValue(lines[s.endpoint], endpoint)
+			continue
+		}
+		line := "Endpoint = " + endpoint
+		if strings.HasSuffix(lines[s.publicKey], "\r") {
+			line += "\r"
+		}
+		lines = append(lines[:s.publicKey+1], append([]string{line}, lines[s.publicKey+1:]...)...)
+	}
+	if !found {
+		return nil, errPeerNotFound
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// replaceINIValue swaps the value of a key = value line, keeping the
+// spacing around = and any trailing comment.
+func replaceINIValue(line, value string) string {
+	eq := strings.IndexByte(line, '=')
+	start := eq + 1
+	for start < len(line) && (line[start] == ' ' || line[start] == '\t') {
+		start++
+	}
+	end := len(line)
+	if hash := strings.IndexByte(line[start:], '#'); hash >= 0 {
+		end = start + hash
+		for end > start && (line[end-1] == ' ' || line[end-1] == '\t') {
+			end--
+		}
+	} else {
+		end = len(strings.TrimRight(line, " \t\r"))
+	}
+	return line[:start] + value + line[end:]
+}
+
+func stripComment(s string) string {
+	if i := strings.IndexByte(s, '#'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// jsonSpan is the byte range of a value within a JSON document.
+type jsonSpan struct {
+	start, end int
+}
+
+// jsonScanner records the member values of every object in a document
+// already checked by json.Valid.
+type jsonScanner struct {
+	data    []byte
+	objects []map[string]jsonSpan
+}
+
+func (s *jsonScanner) skipSpace(i int) int {
+	for i < len(s.data) && strings.IndexByte(" \t\r\n", s.data[i]) >= 0 {
+		i++
+	}
+	return i
+}
+
+// value scans the value starting at i and returns where it ends.
+func (s *jsonScanner) value(i int) int {
+	switch s.data[i] {
+	case '{':
+		return s.object(i)
+	case '[':
+		i = s.skipSpace(i + 1)
+		if s.data[i] == ']' {
+			return i + 1
+		}
+		for {
+			i = s.skipSpace(s.value(s.skipSpace(i)))
+			if s.data[i] == ']' {
+				return i + 1
+			}
+			i++ // ','
+		}
+	case '"':
+		return s.str(i)
+	}
+	for i < len(s.data) && strings.IndexByte(",}] \t\r\n", s.data[i]) < 0 {
+		i++
+	}
+	return i
+}
+
+func (s *jsonScanner) object(i int) int {
+	members := make(map[string]jsonSpan)
+	defer func() { s.objects = append(s.objects, members) }()
+	i = s.skipSpace(i + 1)
+	if s.data[i] == '}' {
+		return i + 1
+	}
+	for {
+		keyEnd := s.str(i)
+		var key string
+		json.Unmarshal(s.data[i:keyEnd], &key)
+		start := s.skipSpace(s.skipSpace(keyEnd) + 1) // past ':'
+		end := s.value(start)
+		members[key] = jsonSpan{start, end}
+		i = s.skipSpace(end)
+		if s.data[i] == '}' {
+			return i + 1
+		}
+		i = s.skipSpace(i + 1) // past ','
+	}
+}
+
+func (s *jsonScanner) str(i int) int {
+	for j := i + 1; j < len(s.data); j++ {
+		switch s.data[j] {
+		case '\\':
+			j++
+		case '"':
+			return j + 1
+		}
+	}
+	return len(s.data)
+}
+
+// applyJSON rewrites the peers of sing-box endpoints and outbounds, which
+// hold the endpoint in address or server and port or server_port, and of
+// Xray outbounds, which hold it in endpoint.
+func applyJSON(data []byte, peerPublicKey, endpoint, host, port string) ([]byte, error) {
+	if !json.Valid(data) {
+		return nil, errInvalidJSON
+	}
+	s := &jsonScanner{data: data}
+	s.value(s.skipSpace(0))
+
+	type replacement struct {
+		span  jsonSpan
+		value string
+	}
+	var replacements []replacement
+	hostValue, _ := json.Marshal(host)
+	endpointValue, _ := json.Marshal(endpoint)
+	for _, members := range s.objects {
+		if !jsonMemberIs(data, members, peerPublicKey, "public_key", "peer_public_key", "publicKey") {
+			continue
+		}
+		if span, ok := members["endpoint"]; ok {
+			replacements = append(replacements, replacement{span, string(endpointValue)})
+			continue
+		}
+		hostSpan, hostOK := firstMember(members, "server", "address")
+		portSpan, portOK := firstMember(members, "server_port", "port")
+		if hostOK && portOK {
+			replacements = append(replacements, replacement{hostSpan, string(hostValue)}, replacement{portSpan, port})
+		}
+	}
+	if len(replacements) == 0 {
+		return nil, errPeerNotFound
+	}
+	sort.Slice(replacements, func(i, j int) bool {
+		return replacements[i].span.start > replacements[j].span.start
+	})
+	out := append([]byte(nil), data...)
+	for _, r := range replacements {
+		out = append(out[:r.span.start], append([]byte(r.value), out[r.span.end:]...)...)
+	}
+	return out, nil
+}
+
+// jsonMemberIs reports whether one of the keys holds the string want.
+func jsonMemberIs(data []byte, members map[string]jsonSpan, want string, keys ...string) bool {
+	for _, key := range keys {
+		if span, ok := members[key]; ok {
+			var value string
+			if json.Unmarshal(data[span.start:span.end], &value) == nil && value == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func firstMember(members map[string]jsonSpan, keys ...string) (jsonSpan, bool) {
+	for _, key := range keys {
+		if span, ok := members[key]; ok {
+			return span, true
+		}
+	}
+	return jsonSpan{}, false
+}
+
+var (
+	yamlItemPattern = regexp.MustCompile(`^(\s*)-\s+`)
+	yamlKeyPattern  = regexp.MustCompile(`^(\s*)([\w-]+):(\s*)(.*)$`)
+	// yamlFlowPatterns find the values in a one-line flow mapping
+	yamlFlowKeyPattern = regexp.MustCompile(`(public-key:\s*)("[^"]*"|'[^']*'|[^,}\s]+)`)
+	yamlFlowServer     = regexp.MustCompile(`((?:^|[{,\s])server:\s*)("[^"]*"|'[^']*'|[^,}\s]+)`)
+	yamlFlowPort       = regexp.MustCompile(`((?:^|[{,\s])port:\s*)("[^"]*"|'[^']*'|[^,}\s]+)`)
+)
+
+// applyClash rewrites server and port of the Clash proxies, or the peers
+// of a proxy, with the public key. Both block and one-line flow mappings
+// are handled.
+func applyClash(data []byte, peerPublicKey, host, port string) ([]byte, error) {
+	lines := strings.Split(string(data), "\n")
+	found := false
+	for i, line := range lines {
+		m := yamlItemPattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		if strings.Contains(line, "{") {
+			if key := yamlFlowKeyPattern.FindStringSubmatch(line); key != nil && yamlScalar(key[2]) == peerPublicKey {
+				line = replaceYAMLFlow(line, yamlFlowServer, host)
+				lines[i] = replaceYAMLFlow(line, yamlFlowPort, port)
+				found = true
+			}
+			continue
+		}
+
+		// the item's own keys are on its first line, after the dash, and
+		// on the following lines indented like that first key
+		itemIndent, keyIndent := len(m[1]), len(m[0])
+		keys := map[string]int{}
+		if k := yamlKeyPattern.FindStringSubmatch(lineAfterDash(line, keyIndent)); k != nil {
+			keys[k[2]] = i
+		}
+		for j := i + 1; j < len(lines); j++ {
+			content := strings.TrimSpace(lines[j])
+			if content == "" || strings.HasPrefix(content, "#") {
+				continue
+			}
+			indent := len(lines[j]) - len(strings.TrimLeft(lines[j], " "))
+			if indent <= itemIndent {
+				break
+			}
+			if k := yamlKeyPattern.FindStringSubmatch(lines[j]); k != nil && indent == keyIndent {
+				keys[k[2]] = j
+			}
+		}
+		keyLine, ok := keys["public-key"]
+		if !ok || yamlScalar(yamlKeyPattern.FindStringSubmatch(lineAfterDash(lines[keyLine], keyIndent))[4]) != peerPublicKey {
+			continue
+		}
+		serverLine, serverOK := keys["server"]
+		portLine, portOK := keys["port"]
+		if !serverOK || !portOK {
+			continue
+		}
+		lines[serverLine] = replaceYAMLValue(lines[serverLine], keyIndent, host)
+		lines[portLine] = replaceYAMLValue(lines[portLine], keyIndent, port)
+		found = true
+	}
+	if !found {
+		return nil, errPeerNotFound
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// lineAfterDash blanks the list dash of an item's first line, so that its
+// key parses like the ones below it.
+func lineAfterDash(line string, keyIndent int) string {
+	if len(line) < keyIndent {
+		return line
+	}
+	return strings.Repeat(" ", keyIndent) + line[keyIndent:]
+}
+
+// replaceYAMLValue swaps the scalar of a key: value line, keeping its
+// quoting style and any trailing comment.
+func replaceYAMLValue(line string, keyIndent int, value string) string {
+	k := yamlKeyPattern.FindStringSubmatch(lineAfterDash(line, keyIndent))
+	raw, comment := splitYAMLComment(k[4])
+	prefix := line[:len(line)-len(k[4])]
+	return prefix + quoteLike(strings.TrimRight(raw, " \t\r"), value) + comment
+}
+
+func replaceYAMLFlow(line string, pattern *regexp.Regexp, value string) string {
+	return pattern.ReplaceAllStringFunc(line, func(match string) string {
+		m := pattern.FindStringSubmatch(match)
+		return m[1] + quoteLike(m[2], value)
+	})
+}
+
+// splitYAMLComment separates a trailing comment, with the spacing before
+// it and any carriage return, from a scalar.
+func splitYAMLComment(s string) (value, rest string) {
+	end := len(strings.TrimRight(s, "\r"))
+	if s != "" && (s[0] == '"' || s[0] == '\'') {
+		for i := 1; i < len(s); i++ {
+			if s[0] == '"' && s[i] == '\\' {
+				i++
+			} else if s[i] == s[0] {
+				end = i + 1
+				break
+			}
+		}
+	} else if i := strings.Index(s, " #"); i >= 0 {
+		end = i
+	}
+	for end > 0 && (s[end-1] == ' ' || s[end-1] == '\t') {
+		end--
+	}
+	return s[:end], s[end:]
+}
+
+// quoteLike writes value quoted the way old is.
+func quoteLike(old, value string) string {
+	switch {
+	case strings.HasPrefix(old, "\""):
+		return strconv.Quote(value)
+	case strings.HasPrefix(old, "'"):
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+	return value
+}
+
+// yamlScalar returns the value of a plain or quoted scalar, without any
+// trailing comment.
+func yamlScalar(s string) string {
+	s, _ = splitYAMLComment(strings.TrimSpace(s))
+	switch {
+	case strings.HasPrefix(s, "\""):
+		if v, err := strconv.Unquote(s); err == nil {
+			return v
+		}
+	case strings.HasPrefix(s, "'") && strings.HasSuffix(s, "'") && len(s) >= 2:
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+	}
+	return s
+}
+
+// Diff returns the changed lines between old and new in unified diff
+// form, without context lines. It compares the lines in a single pass,
+// as Apply only rewrites lines in place or inserts one: a mismatch is an
+// insertion when new has lines to spare and the next one matches again,
+// a changed line otherwise.
+func Diff(name string, old, new []byte) string {
+	a := strings.Split(string(old), "\n")
+	b := strings.Split(string(new), "\n")
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", name, name)
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && a[i] == b[j] {
+			i++
+			j++
+			continue
+		}
+		// collect one hunk of removed and added lines
+		startA, startB := i, j
+		for i < len(a) || j < len(b) {
+			if i < len(a) && j < len(b) && a[i] == b[j] {
+				break
+			}
+			inserted := len(b)-j > len(a)-i && (i == len(a) || j+1 < len(b) && a[i] == b[j+1])
+			switch {
+			case inserted || i == len(a):
+				j++
+			case j == len(b):
+				i++
+			default:
+				i++
+				j++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(startA, i-startA), hunkRange(startB, j-startB))
+		for _, line := range a[startA:i] {
+			fmt.Fprintf(&out, "-%s\n", strings.TrimRight(line, "\r"))
+		}
+		for _, line := range b[startB:j] {
+			fmt.Fprintf(&out, "+%s\n", strings.TrimRight(line, "\r"))
+		}
+	}
+	return out.String()
+}
+
+// hunkRange formats the 1-based line range of a hunk side.
+func hunkRange(start, count int) string {
+	if count == 1 {
+		return strconv.Itoa(start + 1)
+	}
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
@@ -0,0 +1,274 @@
+package export
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	warpKey  = "bmXOC+F1FxEMF9dyiK2H5/1SUtzH0JuVo51h2wPfgyo="
+	otherKey = "cXUfAgrYQDuOeV844dJtz0Zb/hR2XZ9yRbSWfi/5FgQ="
+)
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		endpoint string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name: "wg-quick",
+			config: `[Interface]
+PrivateKey = cGcJ2hcPvT3XXA8fCvOAnA9W5pDqPWgYgO2SXYUTwXE=
+Address = 172.16.0.2/32
+
+# home
+[Peer]
+PublicKey = ` + otherKey + `
+Endpoint = 192.0.2.1:51820
+
+[Peer]
+PublicKey = ` + warpKey + `
+AllowedIPs = 0.0.0.0/0
+Endpoint   =   engage.cloudflareclient.com:2408   # tuned by hand
+`,
+			endpoint: "162.159.192.7:500",
+			want: `[Interface]
+PrivateKey = cGcJ2hcPvT3XXA8fCvOAnA9W5pDqPWgYgO2SXYUTwXE=
+Address = 172.16.0.2/32
+
+# home
+[Peer]
+PublicKey = ` + otherKey + `
+Endpoint = 192.0.2.1:51820
+
+[Peer]
+PublicKey = ` + warpKey + `
+AllowedIPs = 0.0.0.0/0
+Endpoint   =   162.159.192.7:500   # tuned by hand
+`,
+		},
+		{
+			name:     "wg-quick without endpoint",
+			config:   "[Interface]\r\nPrivateKey = x\r\n[peer]\r\npublickey = " + warpKey + "\r\nAllowedIPs = ::/0\r\n",
+			endpoint: "[2606:4700:d0::a29f:c005]:2408",
+			want:     "[Interface]\r\nPrivateKey = x\r\n[peer]\r\npublickey = " + warpKey + "\r\nEndpoint = [2606:4700:d0::a29f:c005]:2408\r\nAllowedIPs = ::/0\r\n",
+		},
+		{
+			name:     "wg-quick without the WARP peer",
+			config:   "[Interface]\nPrivateKey = x\n[Peer]\nPublicKey = " + otherKey + "\n",
+			endpoint: "162.159.192.7:500",
+			wantErr:  true,
+		},
+		{
+			name: "sing-box endpoint and legacy outbound",
+			config: `{
+  "endpoints": [{"type": "wireguard", "tag": "warp", "address": ["172.16.0.2/32"],
+    "peers": [{"address": "engage.cloudflareclient.com", "port": 2408, "public_key": "` + warpKey + `", "reserved": [1, 2, 3]}]}],
+  "outbounds": [
+    {"type": "wireguard", "server": "162.159.193.1", "server_port":2408, "peer_public_key": "` + warpKey + `"},
+    {"type": "wireguard", "server": "192.0.2.1", "server_port": 51820, "peer_public_key": "` + otherKey + `"}
+  ]
+}`,
+			endpoint: "[2606:4700:d0::a29f:c005]:500",
+			want: `{
+  "endpoints": [{"type": "wireguard", "tag": "warp", "address": ["172.16.0.2/32"],
+    "peers": [{"address": "2606:4700:d0::a29f:c005", "port": 500, "public_key": "` + warpKey + `", "reserved": [1, 2, 3]}]}],
+  "outbounds": [
+    {"type": "wireguard", "server": "2606:4700:d0::a29f:c005", "server_port":500, "peer_public_key": "` + warpKey + `"},
+    {"type": "wireguard", "server": "192.0.2.1", "server_port": 51820, "peer_public_key": "` + otherKey + `"}
+  ]
+}`,
+		},
+		{
+			name:     "xray",
+			config:   `{"outbounds": [{"protocol": "wireguard", "settings": {"peers": [{"publicKey": "` + warpKey + `", "endpoint": "engage.cloudflareclient.com:2408"}]}}]}`,
+			endpoint: "162.159.192.7:500",
+			want:     `{"outbounds": [{"protocol": "wireguard", "settings": {"peers": [{"publicKey": "` + warpKey + `", "endpoint": "162.159.192.7:500"}]}}]}`,
+		},
+		{
+			name:     "invalid json",
+			config:   `{"outbounds": [`,
+			endpoint: "162.159.192.7:500",
+			wantErr:  true,
+		},
+		{
+			name: "clash",
+			config: `mixed-port: 7890
+proxies:
+  - name: home
+    type: wireguard
+    server: 192.0.2.1
+    port: 51820
+    public-key: ` + otherKey + `
+  - name: "warp"
+    type: wireguard
+    server: "engage.cloudflareclient.com" # anycast
+    port: 2408
+    ip: 172.16.0.2
+    public-key: "` + warpKey + `"
+    allowed-ips: ['0.0.0.0/0']
+  - {name: warp-flow, type: wireguard, server: 162.159.193.1, port: 2408, public-key: '` + warpKey + `'}
+  - name: warp-peers
+    type: wireguard
+    peers:
+      - server: 162.159.193.2
+        port: 2408
+        public-key: ` + warpKey + `
+`,
+			endpoint: "162.159.192.7:500",
+			want: `mixed-port: 7890
+proxies:
+  - name: home
+    type: wireguard
+    server: 192.0.2.1
+    port: 51820
+    public-key: ` + otherKey + `
+  - name: "warp"
+    type: wireguard
+    server: "162.159.192.7" # anycast
+    port: 500
+    ip: 172.16.0.2
+    public-key: "` + warpKey + `"
+    allowed-ips: ['0.0.0.0/0']
+  - {name: warp-flow, type: wireguard, server: 162.159.192.7, port: 500, public-key: '` + warpKey + `'}
+  - name: warp-peers
+    type: wireguard
+    peers:
+      - server: 162.159.192.7
+        port: 500
+        public-key: ` + warpKey + `
+`,
+		},
+		{
+			name:     "clash without the WARP peer",
+			config:   "proxies:\n  - name: home\n    server: 192.0.2.1\n    port: 51820\n    public-key: " + otherKey + "\n",
+			endpoint: "162.159.192.7:500",
+			wantErr:  true,
+		},
+		{
+			name:     "endpoint without port",
+			config:   "[Peer]\nPublicKey = " + warpKey + "\n",
+			endpoint: "162.159.192.7",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply([]byte(tt.config), warpKey, tt.endpoint)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != tt.want {
+				t.Errorf("Apply() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wg0.conf")
+	original := "[Peer]\nPublicKey = " + warpKey + "\nEndpoint = 162.159.193.1:2408\n"
+	if err := os.WriteFile(path, []byte(original), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := ApplyToFile(path, warpKey, "162.159.192.7:500")
+	if err != nil {
+		t.Fatalf("ApplyToFile() error = %v", err)
+	}
+	wantDiff := "--- " + path + "\n+++ " + path + "\n@@ -3 +3 @@\n-Endpoint = 162.159.193.1:2408\n+Endpoint = 162.159.192.7:500\n"
+	if diff != wantDiff {
+		t.Errorf("ApplyToFile() diff =\n%s\nwant\n%s", diff, wantDiff)
+	}
+	if backup, err := os.ReadFile(path + ".bak"); err != nil || string(backup) != original {
+		t.Errorf("backup = %q, %v, want the original", backup, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Errorf("ApplyToFile() mode = %v, want 0640", info.Mode().Perm())
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 2 {
+		t.Errorf("ApplyToFile() left %d files, want the config and its backup", len(entries))
+	}
+
+	// applying the same endpoint again changes nothing
+	if diff, err := ApplyToFile(path, warpKey, "162.159.192.7:500"); err != nil || diff != "" {
+		t.Errorf("ApplyToFile() again = %q, %v, want no change", diff, err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{
+			name: "changed line",
+			old:  "a\nb\nc",
+			new:  "a\nB\nc",
+			want: "--- f\n+++ f\n@@ -2 +2 @@\n-b\n+B\n",
+		},
+		{
+			name: "inserted line",
+			old:  "a\nc",
+			new:  "a\nb\nc",
+			want: "--- f\n+++ f\n@@ -1,0 +2 @@\n+b\n",
+		},
+		{
+			name: "two hunks",
+			old:  "a\nb\nc\nd",
+			new:  "A\nb\nc\nD\nE",
+			want: "--- f\n+++ f\n@@ -1 +1 @@\n-a\n+A\n@@ -4 +4,2 @@\n-d\n+D\n+E\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Diff("f", []byte(tt.old), []byte(tt.new)); got != tt.want {
+				t.Errorf("Diff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiff_LargeConfig(t *testing.T) {
+	var config strings.Builder
+	config.WriteString("proxies:\n  - name: warp\n    type: wireguard\n    server: 162.159.193.1\n    port: 2408\n    public-key: " + warpKey + "\nrules:\n")
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&config, "  - DOMAIN-SUFFIX,example%d.com,DIRECT\n", i)
+	}
+	old := []byte(config.String())
+	updated, err := Apply(old, warpKey, "162.159.192.7:500")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	got := Diff("f", old, updated)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Diff() took %v", elapsed)
+	}
+	want := "--- f\n+++ f\n@@ -4,2 +4,2 @@\n-    server: 162.159.193.1\n-    port: 2408\n+    server: 162.159.192.7\n+    port: 500\n"
+	if got != want {
+		t.Errorf("Diff() =\n%s\nwant\n%s", got, want)
+	}
+
+	// a line inserted near the end of a long file
+	lines := strings.Split(string(old), "\n")
+	inserted := append(append(append([]string{}, lines[:19000]...), "  - MATCH,warp"), lines[19000:]...)
+	want = "--- f\n+++ f\n@@ -19000,0 +19001 @@\n+  - MATCH,warp\n"
+	if got := Diff("f", old, []byte(strings.Join(inserted, "\n"))); got != want {
+		t.Errorf("Diff() =\n%s\nwant\n%s", got, want)
+	}
+}
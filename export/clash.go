@@ -0,0 +1,81 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	clashTestURL      = "https://www.gstatic.com/generate_204"
+	clashTestInterval = 300
+)
+
+// ClashMeta renders the profiles as Clash.Meta (mihomo) wireguard proxies
+// and a url-test proxy group over them. Strings are written as YAML
+// double-quoted scalars, which share their escaping with Go.
+func ClashMeta(profiles []Profile) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("proxies:\n")
+	for i, p := range profiles {
+		host, port, err := splitEndpoint(p)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, "  - name: %s\n", strconv.Quote(proxyTag(i)))
+		b.WriteString("    type: wireguard\n")
+		fmt.Fprintf(&b, "    server: %s\n", strconv.Quote(host))
+		fmt.Fprintf(&b, "    port: %d\n", port)
+		for _, addr := range p.Addresses {
+			ip, _, _ := strings.Cut(addr, "/")
+			key := "ip"
+			if strings.Contains(ip, ":") {
+				key = "ipv6"
+			}
+			fmt.Fprintf(&b, "    %s: %s\n", key, strconv.Quote(ip))
+		}
+		fmt.Fprintf(&b, "    private-key: %s\n", strconv.Quote(p.PrivateKey))
+		fmt.Fprintf(&b, "    public-key: %s\n", strconv.Quote(p.PeerPublicKey))
+		fmt.Fprintf(&b, "    allowed-ips: %s\n", yamlList(allowedIPs))
+		if len(p.Reserved) > 0 {
+			fmt.Fprintf(&b, "    reserved: %s\n", yamlBytes(p.Reserved))
+		}
+		if p.MTU > 0 {
+			fmt.Fprintf(&b, "    mtu: %d\n", p.MTU)
+		}
+		b.WriteString("    udp: true\n")
+		if len(p.DNS) > 0 {
+			b.WriteString("    remote-dns-resolve: true\n")
+			fmt.Fprintf(&b, "    dns: %s\n", yamlList(p.DNS))
+		}
+	}
+	b.WriteString("\nproxy-groups:\n")
+	fmt.Fprintf(&b, "  - name: %s\n", strconv.Quote(groupTag))
+	b.WriteString("    type: url-test\n")
+	fmt.Fprintf(&b, "    url: %s\n", strconv.Quote(clashTestURL))
+	fmt.Fprintf(&b, "    interval: %d\n", clashTestInterval)
+	b.WriteString("    proxies:\n")
+	for i := range profiles {
+		fmt.Fprintf(&b, "      - %s\n", strconv.Quote(proxyTag(i)))
+	}
+	return b.Bytes(), nil
+}
+
+// yamlBytes writes b as a flow sequence of numbers.
+func yamlBytes(b []byte) string {
+	numbers := make([]string, len(b))
+	for i, v := range b {
+		numbers[i] = strconv.Itoa(int(v))
+	}
+	return "[" + strings.Join(numbers, ", ") + "]"
+}
+
+// yamlList writes items as a flow sequence of quoted strings.
+func yamlList(items []string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = strconv.Quote(item)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
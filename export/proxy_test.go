@@ -0,0 +1,73 @@
+package export
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// goldenProfiles are two of the best endpoints of a scan, one of them
+// reached over IPv6.
+var goldenProfiles = []Profile{
+	{
+		PrivateKey:    "cGcJ2hcPvT3XXA8fCvOAnA9W5pDqPWgYgO2SXYUTwXE=",
+		Addresses:     []string{"172.16.0.2/32", "2606:4700:110:8a36::1/128"},
+		DNS:           []string{"1.1.1.1", "2606:4700:4700::1111"},
+		MTU:           1280,
+		PeerPublicKey: "bmXOC+F1FxEMF9dyiK2H5/1SUtzH0JuVo51h2wPfgyo=",
+		Endpoint:      "162.159.192.7:2408",
+		Reserved:      []byte{60, 189, 175},
+	},
+	{
+		PrivateKey:    "cGcJ2hcPvT3XXA8fCvOAnA9W5pDqPWgYgO2SXYUTwXE=",
+		Addresses:     []string{"172.16.0.2/32", "2606:4700:110:8a36::1/128"},
+		DNS:           []string{"1.1.1.1", "2606:4700:4700::1111"},
+		MTU:           1280,
+		PeerPublicKey: "bmXOC+F1FxEMF9dyiK2H5/1SUtzH0JuVo51h2wPfgyo=",
+		Endpoint:      "[2606:4700:d0::a29f:c005]:500",
+		Reserved:      []byte{60, 189, 175},
+	},
+}
+
+func TestProxyConfigs(t *testing.T) {
+	tests := []struct {
+		name   string
+		render func([]Profile) ([]byte, error)
+		golden string
+	}{
+		{name: "sing-box", render: SingBox, golden: "singbox.json"},
+		{name: "Clash.Meta", render: ClashMeta, golden: "clash.yaml"},
+		{name: "Xray", render: Xray, golden: "xray.json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.render(goldenProfiles)
+			if err != nil {
+				t.Fatalf("render error = %v", err)
+			}
+			path := filepath.Join("testdata", tt.golden)
+			if *update {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("render mismatch with %s, run go test ./export -update after checking the change\ngot:\n%s", path, got)
+			}
+
+			invalid := []Profile{{Endpoint: "162.159.192.7"}}
+			if _, err := tt.render(invalid); err == nil {
+				t.Error("render error = nil for an endpoint without a port")
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// groupTag names the group that picks among the exported endpoints.
+const groupTag = "warp"
+
+// proxyTag names the outbound of the i-th profile.
+func proxyTag(i int) string {
+	return fmt.Sprintf("warp-%d", i+1)
+}
+
+// splitEndpoint splits the endpoint of p into host and port.
+func splitEndpoint(p Profile) (string, int, error) {
+	host, port, err := net.SplitHostPort(p.Endpoint)
+	if err != nil {
+		return "", 0, err
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in endpoint %s", p.Endpoint)
+	}
+	return host, n, nil
+}
+
+type singBoxConfig struct {
+	Endpoints []singBoxEndpoint `json:"endpoints"`
+	Outbounds []singBoxGroup    `json:"outbounds"`
+}
+
+type singBoxEndpoint struct {
+	Type       string        `json:"type"`
+	Tag        string        `json:"tag"`
+	MTU        int           `json:"mtu,omitempty"`
+	Address    []string      `json:"address"`
+	PrivateKey string        `json:"private_key"`
+	Peers      []singBoxPeer `json:"peers"`
+}
+
+type singBoxPeer struct {
+	Address    string   `json:"address"`
+	Port       int      `json:"port"`
+	PublicKey  string   `json:"public_key"`
+	AllowedIPs []string `json:"allowed_ips"`
+	Reserved   []int    `json:"reserved,omitempty"`
+}
+
+type singBoxGroup struct {
+	Type      string   `json:"type"`
+	Tag       string   `json:"tag"`
+	Outbounds []string `json:"outbounds"`
+}
+
+// SingBox renders the profiles as sing-box WireGuard endpoints, in the
+// format of sing-box 1.11 and later, with a urltest outbound choosing
+// among them.
+func SingBox(profiles []Profile) ([]byte, error) {
+	config := singBoxConfig{Outbounds: []singBoxGroup{{Type: "urltest", Tag: groupTag}}}
+	for i, p := range profiles {
+		host, port, err := splitEndpoint(p)
+		if err != nil {
+			return nil, err
+		}
+		config.Endpoints = append(config.Endpoints, singBoxEndpoint{
+			Type:       "wireguard",
+			Tag:        proxyTag(i),
+			MTU:        p.MTU,
+			Address:    p.Addresses,
+			PrivateKey: p.PrivateKey,
+			Peers: []singBoxPeer{{
+				Address:    host,
+				Port:       port,
+				PublicKey:  p.PeerPublicKey,
+				AllowedIPs: allowedIPs,
+				Reserved:   reservedInts(p.Reserved),
+			}},
+		})
+		config.Outbounds[0].Outbounds = append(config.Outbounds[0].Outbounds, proxyTag(i))
+	}
+	return marshalJSON(config)
+}
+
+// reservedInts returns the reserved bytes as numbers, as JSON would encode
+// a byte slice as base64.
+func reservedInts(reserved []byte) []int {
+	if len(reserved) == 0 {
+		return nil
+	}
+	ints := make([]int, len(reserved))
+	for i, b := range reserved {
+		ints[i] = int(b)
+	}
+	return ints
+}
+
+func marshalJSON(v any) ([]byte, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
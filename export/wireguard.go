@@ -0,0 +1,51 @@
+// Package export renders scan results as client configurations.
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Profile is a WireGuard client connecting to one WARP endpoint.
+type Profile struct {
+	PrivateKey string
+	// Addresses are the tunnel addresses in CIDR notation.
+	Addresses []string
+	DNS       []string
+	// MTU is left out when 0.
+	MTU           int
+	PeerPublicKey string
+	Endpoint      string
+	// Reserved holds the WARP reserved bytes, nil when none are configured.
+	Reserved []byte
+}
+
+// allowedIPs routes everything through the tunnel.
+var allowedIPs = []string{"0.0.0.0/0", "::/0"}
+
+// WGQuick renders p as a wg-quick configuration. wg-quick rejects unknown
+// keys, so the reserved bytes are written as a comment for clients that
+// read them.
+func WGQuick(p Profile) []byte {
+	var b bytes.Buffer
+	b.WriteString("[Interface]\n")
+	fmt.Fprintf(&b, "PrivateKey = %s\n", p.PrivateKey)
+	if len(p.Addresses) > 0 {
+		fmt.Fprintf(&b, "Address = %s\n", strings.Join(p.Addresses, ", "))
+	}
+	if len(p.DNS) > 0 {
+		fmt.Fprintf(&b, "DNS = %s\n", strings.Join(p.DNS, ", "))
+	}
+	if p.MTU > 0 {
+		fmt.Fprintf(&b, "MTU = %d\n", p.MTU)
+	}
+	b.WriteString("\n[Peer]\n")
+	fmt.Fprintf(&b, "PublicKey = %s\n", p.PeerPublicKey)
+	fmt.Fprintf(&b, "AllowedIPs = %s\n", strings.Join(allowedIPs, ", "))
+	fmt.Fprintf(&b, "Endpoint = %s\n", p.Endpoint)
+	if len(p.Reserved) == 3 {
+		fmt.Fprintf(&b, "# Reserved = [%d, %d, %d]\n", p.Reserved[0], p.Reserved[1], p.Reserved[2])
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,62 @@
+package export
+
+import "testing"
+
+func TestWGQuick(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile Profile
+		want    string
+	}{
+		{
+			name: "full profile",
+			profile: Profile{
+				PrivateKey:    "cGcJ2hcPvT3XXA8fCvOAnA9W5pDqPWgYgO2SXYUTwXE=",
+				Addresses:     []string{"172.16.0.2/32", "2606:4700:110:8a36::1/128"},
+				DNS:           []string{"1.1.1.1", "2606:4700:4700::1111"},
+				MTU:           1280,
+				PeerPublicKey: "bmXOC+F1FxEMF9dyiK2H5/1SUtzH0JuVo51h2wPfgyo=",
+				Endpoint:      "[2606:4700:d0::1]:2408",
+				Reserved:      []byte{60, 189, 175},
+			},
+			want: `[Interface]
+PrivateKey = cGcJ2hcPvT3XXA8fCvOAnA9W5pDqPWgYgO2SXYUTwXE=
+Address = 172.16.0.2/32, 2606:4700:110:8a36::1/128
+DNS = 1.1.1.1, 2606:4700:4700::1111
+MTU = 1280
+
+[Peer]
+PublicKey = bmXOC+F1FxEMF9dyiK2H5/1SUtzH0JuVo51h2wPfgyo=
+AllowedIPs = 0.0.0.0/0, ::/0
+Endpoint = [2606:4700:d0::1]:2408
+# Reserved = [60, 189, 175]
+`,
+		},
+		{
+			name: "minimal profile",
+			profile: Profile{
+				PrivateKey:    "cGcJ2hcPvT3XXA8fCvOAnA9W5pDqPWgYgO2SXYUTwXE=",
+				Addresses:     []string{"172.16.0.2/32"},
+				PeerPublicKey: "bmXOC+F1FxEMF9dyiK2H5/1SUtzH0JuVo51h2wPfgyo=",
+				Endpoint:      "162.159.192.1:2408",
+			},
+			want: `[Interface]
+PrivateKey = cGcJ2hcPvT3XXA8fCvOAnA9W5pDqPWgYgO2SXYUTwXE=
+Address = 172.16.0.2/32
+
+[Peer]
+PublicKey = bmXOC+F1FxEMF9dyiK2H5/1SUtzH0JuVo51h2wPfgyo=
+AllowedIPs = 0.0.0.0/0, ::/0
+Endpoint = 162.159.192.1:2408
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(WGQuick(tt.profile)); got != tt.want {
+				t.Errorf("WGQuick() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
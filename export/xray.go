@@ -0,0 +1,51 @@
+package export
+
+type xrayConfig struct {
+	Outbounds []xrayOutbound `json:"outbounds"`
+}
+
+type xrayOutbound struct {
+	Protocol string       `json:"protocol"`
+	Tag      string       `json:"tag"`
+	Settings xraySettings `json:"settings"`
+}
+
+type xraySettings struct {
+	SecretKey string     `json:"secretKey"`
+	Address   []string   `json:"address"`
+	Peers     []xrayPeer `json:"peers"`
+	Reserved  []int      `json:"reserved,omitempty"`
+	MTU       int        `json:"mtu,omitempty"`
+}
+
+type xrayPeer struct {
+	PublicKey  string   `json:"publicKey"`
+	AllowedIPs []string `json:"allowedIPs"`
+	Endpoint   string   `json:"endpoint"`
+}
+
+// Xray renders the profiles as Xray wireguard outbounds.
+func Xray(profiles []Profile) ([]byte, error) {
+	var config xrayConfig
+	for i, p := range profiles {
+		if _, _, err := splitEndpoint(p); err != nil {
+			return nil, err
+		}
+		config.Outbounds = append(config.Outbounds, xrayOutbound{
+			Protocol: "wireguard",
+			Tag:      proxyTag(i),
+			Settings: xraySettings{
+				SecretKey: p.PrivateKey,
+				Address:   p.Addresses,
+				Peers: []xrayPeer{{
+					PublicKey:  p.PeerPublicKey,
+					AllowedIPs: allowedIPs,
+					Endpoint:   p.Endpoint,
+				}},
+				Reserved: reservedInts(p.Reserved),
+				MTU:      p.MTU,
+			},
+		})
+	}
+	return marshalJSON(config)
+}
@@ -19,37 +19,154 @@ var localizer *i18n.Localizer
 var folderPrefix = "resources/"
 
 const (
-	TestThreadCount            = "TestThreadCount"
-	LatencyTestTimes           = "LatencyTestTimes"
-	ScanAddressCount           = "ScanAddressCount"
-	TestAllIpPortCombinations  = "TestAllIpPortCombinations"
-	ScanIpv6Only               = "ScanIpv6Only"
-	LatencyUpperLimit          = "LatencyUpperLimit"
-	LatencyLowerLimit          = "LatencyLowerLimit"
-	PacketLossRateUpperLimit   = "PacketLossRateUpperLimit"
-	ResultDisplayCount         = "ResultDisplayCount"
-	IpDataFile                 = "IpDataFile"
-	SpecifyIpData              = "SpecifyIpData"
-	OutputResultFile           = "OutputResultFile"
-	CustomWireguardPrivateKey  = "CustomWireguardPrivateKey"
-	CustomWireguardPublicKey   = "CustomWireguardPublicKey"
-	CustomReservedField        = "CustomReservedField"
-	HelpMessage                = "HelpMessage"
-	ProgramVersion             = "ProgramVersion"
-	CidrInvalid                = "CidrInvalid"
-	Available                  = "available"
-	ReservedEmptyError         = "ReservedEmptyError"
-	ReservedParseError         = "ReservedParseError"
-	PrivateKeyParseError       = "PrivateKeyParseError"
-	PublicKeyParseError        = "PublicKeyParseError"
-	HandshakePacketBuildFailed = "HandshakePacketBuildFailed"
-	Base64Invalid              = "Base64Invalid"
-	NoiseKeyInvalid            = "NoiseKeyInvalid"
-	CreateFileFailed           = "CreateFileFailed"
-	TotalResultZeroSkipOutput  = "TotalResultZeroSkipOutput"
-	WriteResultToFileDone      = "WriteResultToFileDone"
-	PacketLossRate             = "PacketLossRate"
-	Latency                    = "latency"
+	TestThreadCount              = "TestThreadCount"
+	LatencyTestTimes             = "LatencyTestTimes"
+	ScanAddressCount             = "ScanAddressCount"
+	TestAllIpPortCombinations    = "TestAllIpPortCombinations"
+	ScanIpv6Only                 = "ScanIpv6Only"
+	DualStackMode                = "DualStackMode"
+	IPv6Bias                     = "IPv6Bias"
+	SourceAddress                = "SourceAddress"
+	BindInterface                = "BindInterface"
+	SocksProxy                   = "SocksProxy"
+	ProbeMode                    = "ProbeMode"
+	MasqueSNI                    = "MasqueSNI"
+	MasqueTarget                 = "MasqueTarget"
+	LatencyUpperLimit            = "LatencyUpperLimit"
+	LatencyLowerLimit            = "LatencyLowerLimit"
+	PacketLossRateUpperLimit     = "PacketLossRateUpperLimit"
+	JitterUpperLimit             = "JitterUpperLimit"
+	StdDevUpperLimit             = "StdDevUpperLimit"
+	P90LatencyUpperLimit         = "P90LatencyUpperLimit"
+	P99LatencyUpperLimit         = "P99LatencyUpperLimit"
+	LossRunUpperLimit            = "LossRunUpperLimit"
+	LossBurstsUpperLimit         = "LossBurstsUpperLimit"
+	BurstRatioUpperLimit         = "BurstRatioUpperLimit"
+	ResultDisplayCount           = "ResultDisplayCount"
+	IpDataFile                   = "IpDataFile"
+	SpecifyIpData                = "SpecifyIpData"
+	OutputResultFile             = "OutputResultFile"
+	OutputFormat                 = "OutputFormat"
+	OutputFormatInvalid          = "OutputFormatInvalid"
+	OutputWriteFailed            = "OutputWriteFailed"
+	CustomWireguardPrivateKey    = "CustomWireguardPrivateKey"
+	CustomWireguardPublicKey     = "CustomWireguardPublicKey"
+	CustomReservedField          = "CustomReservedField"
+	AccountFile                  = "AccountFile"
+	ForceRegister                = "ForceRegister"
+	APIBase                      = "APIBase"
+	SpeedTestCount               = "SpeedTestCount"
+	SpeedTestDuration            = "SpeedTestDuration"
+	DownloadURL                  = "DownloadURL"
+	UploadURL                    = "UploadURL"
+	TunnelAddress                = "TunnelAddress"
+	WireGuardConfigPath          = "WireGuardConfigPath"
+	WireGuardConfigCount         = "WireGuardConfigCount"
+	WireGuardDNS                 = "WireGuardDNS"
+	WireGuardMTU                 = "WireGuardMTU"
+	SingBoxConfigPath            = "SingBoxConfigPath"
+	ClashConfigPath              = "ClashConfigPath"
+	XrayConfigPath               = "XrayConfigPath"
+	DataPlaneCount               = "DataPlaneCount"
+	DataPlaneMethod              = "DataPlaneMethod"
+	DataPlaneTarget              = "DataPlaneTarget"
+	JunkPacketCount              = "JunkPacketCount"
+	JunkPacketMinSize            = "JunkPacketMinSize"
+	JunkPacketMaxSize            = "JunkPacketMaxSize"
+	InitPacketJunkSize           = "InitPacketJunkSize"
+	ResponsePacketJunkSize       = "ResponsePacketJunkSize"
+	InitPacketMagicHeader        = "InitPacketMagicHeader"
+	ResponsePacketMagicHeader    = "ResponsePacketMagicHeader"
+	UnderloadPacketMagicHeader   = "UnderloadPacketMagicHeader"
+	TransportPacketMagicHeader   = "TransportPacketMagicHeader"
+	PortList                     = "PortList"
+	PacketRate                   = "PacketRate"
+	PrefixConcurrency            = "PrefixConcurrency"
+	ProbeInterval                = "ProbeInterval"
+	ProbeJitter                  = "ProbeJitter"
+	MaxProbeTimeout              = "MaxProbeTimeout"
+	HelpMessage                  = "HelpMessage"
+	ProgramVersion               = "ProgramVersion"
+	IPEntryInvalid               = "IPEntryInvalid"
+	PortInvalid                  = "PortInvalid"
+	SourceAddressInvalid         = "SourceAddressInvalid"
+	SocksProxyInvalid            = "SocksProxyInvalid"
+	ProbeModeInvalid             = "ProbeModeInvalid"
+	MasqueTargetInvalid          = "MasqueTargetInvalid"
+	MasqueProxyUnsupported       = "MasqueProxyUnsupported"
+	Available                    = "available"
+	ReservedParseError           = "ReservedParseError"
+	ReservedFormatInvalid        = "ReservedFormatInvalid"
+	ReservedLengthInvalid        = "ReservedLengthInvalid"
+	ReservedByteInvalid          = "ReservedByteInvalid"
+	PrivateKeyParseError         = "PrivateKeyParseError"
+	PublicKeyParseError          = "PublicKeyParseError"
+	HandshakePacketBuildFailed   = "HandshakePacketBuildFailed"
+	ObfuscationParamInvalid      = "ObfuscationParamInvalid"
+	SocketPoolFailed             = "SocketPoolFailed"
+	IPv6Unavailable              = "IPv6Unavailable"
+	ThroughputPrivateKeyRequired = "ThroughputPrivateKeyRequired"
+	ThroughputTesting            = "ThroughputTesting"
+	DataPlanePrivateKeyRequired  = "DataPlanePrivateKeyRequired"
+	DataPlaneTargetInvalid       = "DataPlaneTargetInvalid"
+	DataPlaneTargetNotIPv4       = "DataPlaneTargetNotIPv4"
+	DataPlaneMethodInvalid       = "DataPlaneMethodInvalid"
+	DataPlaneChecking            = "DataPlaneChecking"
+	Base64Invalid                = "Base64Invalid"
+	NoiseKeyInvalid              = "NoiseKeyInvalid"
+	CreateFileFailed             = "CreateFileFailed"
+	TotalResultZeroSkipOutput    = "TotalResultZeroSkipOutput"
+	WriteResultToFileDone        = "WriteResultToFileDone"
+	ScanInterrupted              = "ScanInterrupted"
+	PartialResults               = "PartialResults"
+	UnknownCommand               = "UnknownCommand"
+	EmulatorStartFailed          = "EmulatorStartFailed"
+	SelfTestPassed               = "SelfTestPassed"
+	SelfTestFailed               = "SelfTestFailed"
+	RegisterFailed               = "RegisterFailed"
+	AccountExists                = "AccountExists"
+	RegisterIncomplete           = "RegisterIncomplete"
+	AccountSaveFailed            = "AccountSaveFailed"
+	AccountLoadFailed            = "AccountLoadFailed"
+	AccountSaved                 = "AccountSaved"
+	AccountRequestFailed         = "AccountRequestFailed"
+	ArgumentMissing              = "ArgumentMissing"
+	AccountRemoveFailed          = "AccountRemoveFailed"
+	AccountDeleted               = "AccountDeleted"
+	AccountDeviceID              = "AccountDeviceID"
+	AccountDeviceName            = "AccountDeviceName"
+	AccountTypeLabel             = "AccountTypeLabel"
+	AccountLicense               = "AccountLicense"
+	AccountQuota                 = "AccountQuota"
+	AccountAddresses             = "AccountAddresses"
+	AccountPeerKey               = "AccountPeerKey"
+	AccountEndpoint              = "AccountEndpoint"
+	AccountReserved              = "AccountReserved"
+	AccountTypeFree              = "AccountTypeFree"
+	AccountTypeLimited           = "AccountTypeLimited"
+	AccountTypeUnlimited         = "AccountTypeUnlimited"
+	AccountTypeTeam              = "AccountTypeTeam"
+	AccountQuotaNone             = "AccountQuotaNone"
+	AccountQuotaUnlimited        = "AccountQuotaUnlimited"
+	AccountQuotaValue            = "AccountQuotaValue"
+	ConfigExportKeyRequired      = "ConfigExportKeyRequired"
+	ConfigExportNoEndpoint       = "ConfigExportNoEndpoint"
+	ConfigExportFailed           = "ConfigExportFailed"
+	ConfigExportDone             = "ConfigExportDone"
+	ConfigExportSkipped          = "ConfigExportSkipped"
+	ApplyFailed                  = "ApplyFailed"
+	ApplySkipped                 = "ApplySkipped"
+	ApplyUnchanged               = "ApplyUnchanged"
+	ApplyDone                    = "ApplyDone"
+	PacketLossRate               = "PacketLossRate"
+	Latency                      = "latency"
+	Status                       = "Status"
+	StatusOK                     = "StatusOK"
+	StatusInvalidResponder       = "StatusInvalidResponder"
+	DataPlaneFailedNote          = "DataPlaneFailedNote"
+	BestIPv4                     = "BestIPv4"
+	BestIPv6                     = "BestIPv6"
+	RecommendedEndpoint          = "RecommendedEndpoint"
 )
 
 func init() {
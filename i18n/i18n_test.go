@@ -67,7 +67,7 @@ func TestQueryTemplateI18n(t *testing.T) {
 			tpData: map[string]interface{}{
 				"file": "test.csv",
 			},
-			want: "Write result to file; add quotes if the path contains spaces; empty value means not writing to a file [-o \"\"]; ",
+			want: "Write results to these files, separated by commas; \"-\" writes to standard output; add quotes if a path contains spaces; empty value means not writing to a file [-o \"\"]; ",
 		},
 		{
 			name:      "chinese template",
@@ -76,7 +76,7 @@ func TestQueryTemplateI18n(t *testing.T) {
 			tpData: map[string]interface{}{
 				"file": "test.csv",
 			},
-			want: "设置输出结果文件 [默认文件为 \"result.csv\"]",
+			want: "设置输出结果文件，多个文件以逗号分隔，\"-\" 表示标准输出 [默认文件为 \"result.csv\"]",
 		},
 	}
 
@@ -1,53 +1,139 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/peanut996/CloudflareWarpSpeedTest/i18n"
 
 	"github.com/peanut996/CloudflareWarpSpeedTest/task"
 	"github.com/peanut996/CloudflareWarpSpeedTest/utils"
+	"github.com/peanut996/CloudflareWarpSpeedTest/warp"
 )
 
 var (
 	Version string
+
+	// command is the subcommand given before the options, if any, and
+	// commandArgs the words that follow it.
+	command     string
+	commandArgs []string
 )
 
 func init() {
 	var printVersion bool
+	var speedTestSeconds int
+	var probeInterval, probeJitter, maxProbeTimeout int
 	var minDelay, maxDelay int
+	var maxJitter, maxStdDev, maxP90Delay, maxP99Delay int
 	var maxLossRate float64
+	var ipv6Bias int
 	flag.IntVar(&task.Routines, "n", 200, i18n.QueryI18n(i18n.TestThreadCount))
 	flag.IntVar(&task.PingTimes, "t", 10, i18n.QueryI18n(i18n.LatencyTestTimes))
 	flag.IntVar(&task.MaxScanCount, "c", 5000, i18n.QueryI18n(i18n.ScanAddressCount))
+	flag.IntVar(&task.PacketRate, "pps", 0, i18n.QueryI18n(i18n.PacketRate))
+	flag.IntVar(&task.PrefixConcurrency, "pc", 0, i18n.QueryI18n(i18n.PrefixConcurrency))
+	flag.IntVar(&probeInterval, "pi", 0, i18n.QueryI18n(i18n.ProbeInterval))
+	flag.IntVar(&probeJitter, "pj", 0, i18n.QueryI18n(i18n.ProbeJitter))
+	flag.IntVar(&maxProbeTimeout, "to", 1000, i18n.QueryI18n(i18n.MaxProbeTimeout))
 
 	flag.IntVar(&maxDelay, "tl", 300, i18n.QueryI18n(i18n.LatencyUpperLimit))
 	flag.IntVar(&minDelay, "tll", 0, i18n.QueryI18n(i18n.LatencyLowerLimit))
 	flag.Float64Var(&maxLossRate, "tlr", 1, i18n.QueryI18n(i18n.PacketLossRateUpperLimit))
+	flag.IntVar(&maxJitter, "tlj", 9999, i18n.QueryI18n(i18n.JitterUpperLimit))
+	flag.IntVar(&maxStdDev, "tlsd", 9999, i18n.QueryI18n(i18n.StdDevUpperLimit))
+	flag.IntVar(&maxP90Delay, "tlp90", 9999, i18n.QueryI18n(i18n.P90LatencyUpperLimit))
+	flag.IntVar(&maxP99Delay, "tlp99", 9999, i18n.QueryI18n(i18n.P99LatencyUpperLimit))
+	flag.IntVar(&utils.InputMaxLossRun, "tlmr", utils.InputMaxLossRun, i18n.QueryI18n(i18n.LossRunUpperLimit))
+	flag.IntVar(&utils.InputMaxLossBursts, "tlbc", utils.InputMaxLossBursts, i18n.QueryI18n(i18n.LossBurstsUpperLimit))
+	flag.Float64Var(&utils.InputMaxBurstRatio, "tlbr", utils.InputMaxBurstRatio, i18n.QueryI18n(i18n.BurstRatioUpperLimit))
 
 	flag.BoolVar(&task.AllMode, "all", false, i18n.QueryI18n(i18n.TestAllIpPortCombinations))
 	flag.BoolVar(&task.IPv6Mode, "ipv6", false, i18n.QueryI18n(i18n.ScanIpv6Only))
+	flag.BoolVar(&task.DualStackMode, "dual", false, i18n.QueryI18n(i18n.DualStackMode))
+	flag.IntVar(&ipv6Bias, "v6bias", 50, i18n.QueryI18n(i18n.IPv6Bias))
+	flag.StringVar(&task.SourceAddress, "source", "", i18n.QueryI18n(i18n.SourceAddress))
+	flag.StringVar(&task.BindInterface, "interface", "", i18n.QueryI18n(i18n.BindInterface))
+	flag.StringVar(&task.SocksProxy, "socks5", "", i18n.QueryI18n(i18n.SocksProxy))
+	flag.StringVar(&task.ProbeMode, "mode", task.ProbeMode, i18n.QueryI18n(i18n.ProbeMode))
+	flag.StringVar(&task.MasqueSNI, "masque-sni", task.MasqueSNI, i18n.QueryI18n(i18n.MasqueSNI))
+	flag.StringVar(&task.MasqueTarget, "masque-target", task.MasqueTarget, i18n.QueryI18n(i18n.MasqueTarget))
 	flag.IntVar(&utils.PrintNum, "p", 10, i18n.QueryI18n(i18n.ResultDisplayCount))
 	flag.StringVar(&task.IPFile, "f", "", i18n.QueryI18n(i18n.IpDataFile))
 	flag.StringVar(&task.IPText, "ip", "", i18n.QueryI18n(i18n.SpecifyIpData))
+	flag.StringVar(&task.PortText, "port", "", i18n.QueryI18n(i18n.PortList))
 	flag.StringVar(&utils.Output, "o", "result.csv", i18n.QueryI18n(i18n.OutputResultFile))
+	flag.StringVar(&utils.OutputFormat, "of", "", i18n.QueryI18n(i18n.OutputFormat))
 	flag.StringVar(&task.PrivateKey, "pri", "", i18n.QueryI18n(i18n.CustomWireguardPrivateKey))
 	flag.StringVar(&task.PublicKey, "pub", "", i18n.QueryI18n(i18n.CustomWireguardPublicKey))
 	flag.StringVar(&task.ReservedString, "reserved", "", i18n.QueryI18n(i18n.CustomReservedField))
+	flag.StringVar(&accountFile, "account", "", i18n.QueryI18n(i18n.AccountFile))
+	flag.BoolVar(&forceRegister, "force", false, i18n.QueryI18n(i18n.ForceRegister))
+	flag.StringVar(&warp.APIBase, "api", warp.APIBase, i18n.QueryI18n(i18n.APIBase))
+	flag.IntVar(&task.SpeedTestCount, "dn", 0, i18n.QueryI18n(i18n.SpeedTestCount))
+	flag.IntVar(&speedTestSeconds, "dt", 10, i18n.QueryI18n(i18n.SpeedTestDuration))
+	flag.StringVar(&task.DownloadURL, "url", task.DownloadURL, i18n.QueryI18n(i18n.DownloadURL))
+	flag.StringVar(&task.UploadURL, "uurl", task.UploadURL, i18n.QueryI18n(i18n.UploadURL))
+	flag.StringVar(&task.TunnelAddress, "tunaddr", task.TunnelAddress, i18n.QueryI18n(i18n.TunnelAddress))
+	flag.StringVar(&wgConfigPath, "wg", "", i18n.QueryI18n(i18n.WireGuardConfigPath))
+	flag.IntVar(&exportCount, "wgn", exportCount, i18n.QueryI18n(i18n.WireGuardConfigCount))
+	flag.StringVar(&wgDNS, "wgdns", wgDNS, i18n.QueryI18n(i18n.WireGuardDNS))
+	flag.IntVar(&wgMTU, "wgmtu", wgMTU, i18n.QueryI18n(i18n.WireGuardMTU))
+	flag.StringVar(&singBoxPath, "singbox", "", i18n.QueryI18n(i18n.SingBoxConfigPath))
+	flag.StringVar(&clashPath, "clash", "", i18n.QueryI18n(i18n.ClashConfigPath))
+	flag.StringVar(&xrayPath, "xray", "", i18n.QueryI18n(i18n.XrayConfigPath))
+	flag.IntVar(&task.DataPlaneCount, "dp", 0, i18n.QueryI18n(i18n.DataPlaneCount))
+	flag.StringVar(&task.DataPlaneMethod, "dpm", task.DataPlaneMethod, i18n.QueryI18n(i18n.DataPlaneMethod))
+	flag.StringVar(&task.DataPlaneTarget, "dpt", task.DataPlaneTarget, i18n.QueryI18n(i18n.DataPlaneTarget))
+	flag.IntVar(&task.JunkPacketCount, "jc", 0, i18n.QueryI18n(i18n.JunkPacketCount))
+	flag.IntVar(&task.JunkPacketMinSize, "jmin", 0, i18n.QueryI18n(i18n.JunkPacketMinSize))
+	flag.IntVar(&task.JunkPacketMaxSize, "jmax", 0, i18n.QueryI18n(i18n.JunkPacketMaxSize))
+	flag.IntVar(&task.InitPacketJunkSize, "s1", 0, i18n.QueryI18n(i18n.InitPacketJunkSize))
+	flag.IntVar(&task.ResponsePacketJunkSize, "s2", 0, i18n.QueryI18n(i18n.ResponsePacketJunkSize))
+	flag.UintVar(&task.InitPacketMagicHeader, "h1", task.InitPacketMagicHeader, i18n.QueryI18n(i18n.InitPacketMagicHeader))
+	flag.UintVar(&task.ResponsePacketMagicHeader, "h2", task.ResponsePacketMagicHeader, i18n.QueryI18n(i18n.ResponsePacketMagicHeader))
+	flag.UintVar(&task.UnderloadPacketMagicHeader, "h3", task.UnderloadPacketMagicHeader, i18n.QueryI18n(i18n.UnderloadPacketMagicHeader))
+	flag.UintVar(&task.TransportPacketMagicHeader, "h4", task.TransportPacketMagicHeader, i18n.QueryI18n(i18n.TransportPacketMagicHeader))
 	flag.BoolVar(&printVersion, "v", false, i18n.QueryI18n(i18n.ProgramVersion))
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, `CloudflareWarpSpeedTest `+"\n\n"+i18n.QueryI18n(i18n.HelpMessage))
 		flag.PrintDefaults()
 	}
-	flag.Parse()
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		commandArgs, args = append(commandArgs, args[0]), args[1:]
+	}
+	flag.CommandLine.Parse(args)
+	commandArgs = append(commandArgs, flag.Args()...)
 
 	utils.InputMaxDelay = time.Duration(maxDelay) * time.Millisecond
 	utils.InputMinDelay = time.Duration(minDelay) * time.Millisecond
 	utils.InputMaxLossRate = float32(maxLossRate)
+	task.SpeedTestDuration = time.Duration(speedTestSeconds) * time.Second
+	task.ProbeInterval = time.Duration(probeInterval) * time.Millisecond
+	task.ProbeJitter = time.Duration(probeJitter) * time.Millisecond
+	task.MaxProbeTimeout = time.Duration(maxProbeTimeout) * time.Millisecond
+	utils.InputMaxJitter = time.Duration(maxJitter) * time.Millisecond
+	utils.InputMaxStdDev = time.Duration(maxStdDev) * time.Millisecond
+	utils.InputMaxP90Delay = time.Duration(maxP90Delay) * time.Millisecond
+	utils.InputMaxP99Delay = time.Duration(maxP99Delay) * time.Millisecond
+	utils.IPv6Bias = time.Duration(ipv6Bias) * time.Millisecond
+
+	// keep standard output for the results alone
+	if utils.UsesStdout() {
+		os.Stdout = os.Stderr
+	}
 
 	if printVersion {
 		fmt.Println(Version)
@@ -56,11 +142,72 @@ func init() {
 }
 
 func main() {
-	task.InitHandshakePacket()
-
 	fmt.Printf("CloudflareWarpSpeedTest\n\n")
 
-	pingData := task.NewWarping().Run().FilterDelay().FilterLossRate()
-	utils.ExportCsv(pingData)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		// a second signal terminates the process as usual
+		signal.Stop(sig)
+		fmt.Println("\n" + i18n.QueryI18n(i18n.ScanInterrupted))
+		cancel()
+	}()
+
+	switch command {
+	case "":
+	case "selftest":
+		if !runSelfTest(ctx) {
+			os.Exit(1)
+		}
+		return
+	case "register":
+		runRegister(ctx)
+		return
+	case "account":
+		runAccount(ctx, commandArgs)
+		return
+	case "apply-to":
+		if len(commandArgs) == 0 {
+			log.Fatalln(i18n.QueryI18n(i18n.ArgumentMissing) + command)
+		}
+		// fail before the scan rather than after it
+		if _, err := os.Stat(commandArgs[0]); err != nil {
+			log.Fatalln(i18n.QueryI18n(i18n.ApplyFailed) + err.Error())
+		}
+	default:
+		fmt.Fprintln(os.Stderr, i18n.QueryI18n(i18n.UnknownCommand)+command)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	loadAccount()
+	task.InitHandshakePacket()
+	sinks, err := utils.OpenSinks()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	task.ResultSinks = sinks
+	pingData := task.NewWarping().Run(ctx).FilterDelay().FilterLossRate().FilterLatencyStats().FilterLossPattern()
+	pingData = task.CheckDataPlane(ctx, pingData)
+	pingData = task.TestThroughput(ctx, pingData)
+	if err := sinks.Write(pingData); err != nil {
+		log.Fatalln(i18n.QueryI18n(i18n.OutputWriteFailed) + err.Error())
+	}
+	if err := sinks.Close(); err != nil {
+		log.Fatalln(i18n.QueryI18n(i18n.OutputWriteFailed) + err.Error())
+	}
 	pingData.Print()
+	exportConfigs(ctx, pingData)
+	if command == "apply-to" {
+		applyTo(ctx, commandArgs[0], pingData)
+	}
+	if task.DualStackMode {
+		pingData.PrintDualStack()
+	}
+	if ctx.Err() != nil {
+		fmt.Println(i18n.QueryI18n(i18n.PartialResults))
+	}
 }
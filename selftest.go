@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/peanut996/CloudflareWarpSpeedTest/emulator"
+	"github.com/peanut996/CloudflareWarpSpeedTest/i18n"
+	"github.com/peanut996/CloudflareWarpSpeedTest/task"
+	"github.com/peanut996/CloudflareWarpSpeedTest/utils"
+	"golang.zx2c4.com/wireguard/device"
+)
+
+const (
+	selfTestSeed = 1
+	// selfTestProbes and selfTestTimeout replace -t and -to, as the checks
+	// rely on what the seeded emulator does with this many probes.
+	selfTestProbes  = 10
+	selfTestTimeout = 500 * time.Millisecond
+)
+
+// selfTestCase is an emulated endpoint and the result the scan must report
+// for it; data is nil when the endpoint is missing from the results.
+type selfTestCase struct {
+	name    string
+	profile emulator.Profile
+	check   func(data *utils.CloudflareIPData) bool
+}
+
+var selfTestCases = []selfTestCase{
+	{
+		name:    "fast",
+		profile: emulator.Profile{Latency: 5 * time.Millisecond, Jitter: time.Millisecond},
+		check: func(data *utils.CloudflareIPData) bool {
+			return data != nil && data.Status == utils.StatusOK && data.Received == data.Sent
+		},
+	},
+	{
+		name:    "jittery",
+		profile: emulator.Profile{Latency: 40 * time.Millisecond, Jitter: 30 * time.Millisecond},
+		check: func(data *utils.CloudflareIPData) bool {
+			return data != nil && data.Status == utils.StatusOK && data.MaxDelay > data.MinDelay
+		},
+	},
+	{
+		name:    "lossy",
+		profile: emulator.Profile{Latency: 10 * time.Millisecond, Loss: 0.4},
+		check: func(data *utils.CloudflareIPData) bool {
+			return data != nil && data.Status == utils.StatusOK && data.Received < data.Sent && data.LossBursts > 0
+		},
+	},
+	{
+		name:    "cookie",
+		profile: emulator.Profile{Latency: 10 * time.Millisecond, CookieReply: true},
+		check: func(data *utils.CloudflareIPData) bool {
+			return data != nil && data.Status == utils.StatusOK && data.CookieChallenged == data.Sent
+		},
+	},
+	{
+		name:    "garbage",
+		profile: emulator.Profile{Garbage: true},
+		check: func(data *utils.CloudflareIPData) bool {
+			return data != nil && data.Status == utils.StatusInvalidResponder
+		},
+	},
+	{
+		name:    "wrong-size",
+		profile: emulator.Profile{WrongSize: true},
+		check: func(data *utils.CloudflareIPData) bool {
+			return data != nil && data.Status == utils.StatusInvalidResponder
+		},
+	},
+	{
+		name:    "silent",
+		profile: emulator.Profile{Loss: 1},
+		check: func(data *utils.CloudflareIPData) bool {
+			return data == nil
+		},
+	},
+}
+
+// runSelfTest scans local emulated endpoints with the configured key and
+// obfuscation settings and checks that every one is reported as expected.
+// Without a private key the built-in packet is used, otherwise the probes
+// are fully verified against the emulator's key.
+func runSelfTest(ctx context.Context) bool {
+	var pri device.NoisePrivateKey
+	rand.Read(pri[:])
+	warpPub, _ := base64.StdEncoding.DecodeString(task.WarpPublicKey)
+	var foreign device.NoisePublicKey
+	copy(foreign[:], warpPub)
+
+	cfg := emulator.Config{
+		PrivateKey:  pri,
+		ForeignKeys: []device.NoisePublicKey{foreign},
+		Obfuscation: emulator.Obfuscation{
+			InitJunkSize:     task.InitPacketJunkSize,
+			ResponseJunkSize: task.ResponsePacketJunkSize,
+			InitHeader:       uint32(task.InitPacketMagicHeader),
+			ResponseHeader:   uint32(task.ResponsePacketMagicHeader),
+			CookieHeader:     uint32(task.UnderloadPacketMagicHeader),
+		},
+		Seed: selfTestSeed,
+	}
+	for _, c := range selfTestCases {
+		cfg.Endpoints = append(cfg.Endpoints, c.profile)
+	}
+	em, err := emulator.Start(cfg)
+	if err != nil {
+		log.Fatalln(i18n.QueryI18n(i18n.EmulatorStartFailed) + err.Error())
+	}
+	defer em.Close()
+
+	addrs := em.Addrs()
+	targets := make([]string, len(addrs))
+	for i, addr := range addrs {
+		targets[i] = addr.String()
+	}
+	task.IPText = strings.Join(targets, ",")
+	task.IPFile = ""
+	if task.PrivateKey != "" {
+		pub := em.PublicKey()
+		task.PublicKey = base64.StdEncoding.EncodeToString(pub[:])
+	}
+	task.PingTimes = selfTestProbes
+	task.MaxProbeTimeout = selfTestTimeout
+	task.InitHandshakePacket()
+	utils.Output = ""
+
+	pingData := task.NewWarping().Run(ctx)
+	pingData.Print()
+
+	found := make(map[string]*utils.CloudflareIPData, len(pingData))
+	for i := range pingData {
+		found[pingData[i].IP.String()] = &pingData[i]
+	}
+	passed := true
+	fmt.Println()
+	for i, c := range selfTestCases {
+		result := "ok"
+		if !c.check(found[targets[i]]) {
+			result = "FAIL"
+			passed = false
+		}
+		fmt.Printf("%-12s%-22s%s\n", c.name, targets[i], result)
+	}
+	if passed {
+		fmt.Println(i18n.QueryI18n(i18n.SelfTestPassed))
+	} else {
+		fmt.Fprintln(os.Stderr, i18n.QueryI18n(i18n.SelfTestFailed))
+	}
+	return passed
+}
@@ -0,0 +1,168 @@
+package task
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"math"
+	mrand "math/rand/v2"
+
+	"github.com/peanut996/CloudflareWarpSpeedTest/i18n"
+	"golang.zx2c4.com/wireguard/device"
+)
+
+// AmneziaWG limits, chosen so that padded handshake messages still fit in
+// a 1280 byte datagram.
+const (
+	maxJunkPacketCount          = 128
+	maxJunkPacketSize           = 1280
+	maxInitPacketJunkSize       = maxJunkPacketSize - device.MessageInitiationSize
+	maxResponseJunkSize         = maxJunkPacketSize - device.MessageResponseSize
+	initResponseSizeDiff        = device.MessageInitiationSize - device.MessageResponseSize
+	defaultInitMagicHeader      = device.MessageInitiationType
+	defaultRespMagicHeader      = device.MessageResponseType
+	defaultCookieMagicHeader    = device.MessageCookieReplyType
+	defaultTransportMagicHeader = device.MessageTransportType
+)
+
+// AmneziaWG obfuscation parameters. The zero junk sizes and default headers
+// produce vanilla WireGuard packets.
+var (
+	JunkPacketCount   = 0
+	JunkPacketMinSize = 0
+	JunkPacketMaxSize = 0
+
+	InitPacketJunkSize     = 0
+	ResponsePacketJunkSize = 0
+
+	InitPacketMagicHeader      uint = defaultInitMagicHeader
+	ResponsePacketMagicHeader  uint = defaultRespMagicHeader
+	UnderloadPacketMagicHeader uint = defaultCookieMagicHeader
+	TransportPacketMagicHeader uint = defaultTransportMagicHeader
+)
+
+// customHeaders reports whether the message type headers are replaced, in
+// which case the whole first word of a message is the header and there is
+// no room for the WARP reserved bytes.
+func customHeaders() bool {
+	return InitPacketMagicHeader != defaultInitMagicHeader ||
+		ResponsePacketMagicHeader != defaultRespMagicHeader ||
+		UnderloadPacketMagicHeader != defaultCookieMagicHeader ||
+		TransportPacketMagicHeader != defaultTransportMagicHeader
+}
+
+func checkObfuscation() error {
+	if JunkPacketCount < 0 || JunkPacketCount > maxJunkPacketCount {
+		return errors.New("jc must be between 0 and 128")
+	}
+	if JunkPacketMinSize < 0 || JunkPacketMinSize > JunkPacketMaxSize || JunkPacketMaxSize > maxJunkPacketSize {
+		return errors.New("jmin and jmax must satisfy 0 <= jmin <= jmax <= 1280")
+	}
+	if JunkPacketCount > 0 && JunkPacketMaxSize == 0 {
+		return errors.New("jmax must be set when jc is greater than 0")
+	}
+	if InitPacketJunkSize < 0 || InitPacketJunkSize > maxInitPacketJunkSize {
+		return errors.New("s1 must be between 0 and 1132")
+	}
+	if ResponsePacketJunkSize < 0 || ResponsePacketJunkSize > maxResponseJunkSize {
+		return errors.New("s2 must be between 0 and 1188")
+	}
+	if InitPacketJunkSize != 0 && InitPacketJunkSize+initResponseSizeDiff == ResponsePacketJunkSize {
+		return errors.New("s1 + 56 must not equal s2")
+	}
+	headers := []uint{InitPacketMagicHeader, ResponsePacketMagicHeader, UnderloadPacketMagicHeader, TransportPacketMagicHeader}
+	seen := make(map[uint]bool, len(headers))
+	for _, h := range headers {
+		if h == 0 || h > math.MaxUint32 {
+			return errors.New("h1-h4 must be between 1 and 4294967295")
+		}
+		if seen[h] {
+			return errors.New("h1-h4 must be distinct")
+		}
+		seen[h] = true
+	}
+	return nil
+}
+
+// initObfuscation validates the AmneziaWG parameters and re-signs the
+// built-in packet when its header changes.
+func initObfuscation() {
+	if err := checkObfuscation(); err != nil {
+		log.Fatalln(i18n.QueryI18n(i18n.ObfuscationParamInvalid) + err.Error())
+	}
+	if !customHeaders() {
+		return
+	}
+	packet := make([]byte, len(warpHandshakePacket))
+	copy(packet, warpHandshakePacket)
+	generator := device.CookieGenerator{}
+	generator.Init(builtinPublicKey())
+	sealInitiation(packet, &generator)
+	warpHandshakePacket = packet
+}
+
+// sealInitiation writes the header and MACs of a handshake initiation.
+// Vanilla WARP packets carry the reserved bytes, which are left out of the
+// MACs; AmneziaWG replaces the whole header with H1 and covers it.
+func sealInitiation(packet []byte, generator *device.CookieGenerator) {
+	if customHeaders() {
+		binary.LittleEndian.PutUint32(packet, uint32(InitPacketMagicHeader))
+		generator.AddMacs(packet)
+		return
+	}
+	packet[0], packet[1], packet[2], packet[3] = device.MessageInitiationType, 0, 0, 0
+	generator.AddMacs(packet)
+	AddReserved(packet)
+}
+
+// hasHeader reports whether msg starts with the given message type header.
+// Without custom headers only the type byte is compared, as WARP may put
+// reserved bytes in the rest of the word.
+func hasHeader(msg []byte, header uint) bool {
+	if len(msg) < 4 {
+		return false
+	}
+	if customHeaders() {
+		return binary.LittleEndian.Uint32(msg) == uint32(header)
+	}
+	return msg[0] == byte(header)
+}
+
+// padInitiation prefixes an initiation with S1 random bytes.
+func padInitiation(msg []byte) []byte {
+	if InitPacketJunkSize == 0 {
+		return msg
+	}
+	data := make([]byte, InitPacketJunkSize+len(msg))
+	rand.Read(data[:InitPacketJunkSize])
+	copy(data[InitPacketJunkSize:], msg)
+	return data
+}
+
+// stripResponsePadding removes the S2 random prefix of a handshake response.
+// It returns nil if buf is too short to carry the prefix.
+func stripResponsePadding(buf []byte) []byte {
+	if len(buf) < ResponsePacketJunkSize {
+		return nil
+	}
+	return buf[ResponsePacketJunkSize:]
+}
+
+// sendJunk writes Jc random packets of Jmin to Jmax bytes ahead of an
+// initiation.
+func sendJunk(conn io.Writer) error {
+	for i := 0; i < JunkPacketCount; i++ {
+		size := JunkPacketMinSize
+		if JunkPacketMaxSize > JunkPacketMinSize {
+			size += mrand.IntN(JunkPacketMaxSize - JunkPacketMinSize + 1)
+		}
+		junk := make([]byte, size)
+		rand.Read(junk)
+		if _, err := conn.Write(junk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package task
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+type obfuscationParams struct {
+	jc, jmin, jmax, s1, s2 int
+	h1, h2, h3, h4         uint
+}
+
+func setObfuscation(t *testing.T, p obfuscationParams) {
+	t.Helper()
+	orig := obfuscationParams{
+		JunkPacketCount, JunkPacketMinSize, JunkPacketMaxSize, InitPacketJunkSize, ResponsePacketJunkSize,
+		InitPacketMagicHeader, ResponsePacketMagicHeader, UnderloadPacketMagicHeader, TransportPacketMagicHeader,
+	}
+	t.Cleanup(func() { applyObfuscation(orig) })
+	applyObfuscation(p)
+}
+
+func applyObfuscation(p obfuscationParams) {
+	JunkPacketCount, JunkPacketMinSize, JunkPacketMaxSize = p.jc, p.jmin, p.jmax
+	InitPacketJunkSize, ResponsePacketJunkSize = p.s1, p.s2
+	InitPacketMagicHeader, ResponsePacketMagicHeader = p.h1, p.h2
+	UnderloadPacketMagicHeader, TransportPacketMagicHeader = p.h3, p.h4
+}
+
+func TestCheckObfuscation(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  obfuscationParams
+		wantErr bool
+	}{
+		{name: "vanilla", params: obfuscationParams{h1: 1, h2: 2, h3: 3, h4: 4}},
+		{name: "typical amnezia", params: obfuscationParams{jc: 4, jmin: 40, jmax: 70, s1: 15, s2: 40, h1: 1106457265, h2: 249455488, h3: 1209847463, h4: 1646644382}},
+		{name: "jmin above jmax", params: obfuscationParams{jc: 1, jmin: 80, jmax: 70, h1: 1, h2: 2, h3: 3, h4: 4}, wantErr: true},
+		{name: "junk without size", params: obfuscationParams{jc: 1, h1: 1, h2: 2, h3: 3, h4: 4}, wantErr: true},
+		{name: "s1 too large", params: obfuscationParams{s1: 1200, h1: 1, h2: 2, h3: 3, h4: 4}, wantErr: true},
+		{name: "padded sizes collide", params: obfuscationParams{s1: 10, s2: 66, h1: 1, h2: 2, h3: 3, h4: 4}, wantErr: true},
+		{name: "duplicate headers", params: obfuscationParams{h1: 5, h2: 5, h3: 3, h4: 4}, wantErr: true},
+		{name: "header overflow", params: obfuscationParams{h1: 1 << 32, h2: 2, h3: 3, h4: 4}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setObfuscation(t, tt.params)
+			if err := checkObfuscation(); (err != nil) != tt.wantErr {
+				t.Errorf("checkObfuscation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProbePacket_Obfuscated(t *testing.T) {
+	setObfuscation(t, obfuscationParams{s1: 15, s2: 40, h1: 1106457265, h2: 249455488, h3: 1209847463, h4: 1646644382})
+
+	initiatorKey := newTestPrivateKey(t)
+	responderKey := newTestPrivateKey(t)
+	responderPub := publicKeyOf(responderKey)
+
+	origIdentity := identity
+	defer func() { identity = origIdentity }()
+	identity = newTestIdentity(t, initiatorKey, responderPub)
+
+	probe, err := newProbePacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(probe.data) != 15+device.MessageInitiationSize {
+		t.Fatalf("newProbePacket() datagram size = %v, want %v", len(probe.data), 15+device.MessageInitiationSize)
+	}
+	if !bytes.Equal(probe.data[15:], probe.msg) {
+		t.Fatal("newProbePacket() datagram should end with the initiation")
+	}
+	if got := binary.LittleEndian.Uint32(probe.msg); got != 1106457265 {
+		t.Errorf("initiation header = %v, want H1", got)
+	}
+	checker := device.CookieChecker{}
+	checker.Init(responderPub)
+	if !checker.CheckMAC1(probe.msg) {
+		t.Error("initiation MAC1 should cover the custom header")
+	}
+
+	// answer like an AmneziaWG server: vanilla processing behind the headers
+	vanilla := append([]byte(nil), probe.msg...)
+	binary.LittleEndian.PutUint32(vanilla, device.MessageInitiationType)
+	resp := respondTo(t, responderKey, publicKeyOf(initiatorKey), vanilla)
+	binary.LittleEndian.PutUint32(resp, 249455488)
+	generator := device.CookieGenerator{}
+	generator.Init(publicKeyOf(initiatorKey))
+	generator.AddMacs(resp)
+	padded := make([]byte, 40+len(resp))
+	rand.Read(padded[:40])
+	copy(padded[40:], resp)
+
+	if !probe.verifyResponse(padded) {
+		t.Error("verifyResponse() rejected a padded response with a custom header")
+	}
+	if probe.verifyResponse(resp) {
+		t.Error("verifyResponse() accepted a response without the S2 padding")
+	}
+}
+
+func TestSendJunk(t *testing.T) {
+	setObfuscation(t, obfuscationParams{jc: 3, jmin: 10, jmax: 20, h1: 1, h2: 2, h3: 3, h4: 4})
+
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := net.Dial("udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := sendJunk(client); err != nil {
+		t.Fatalf("sendJunk() error = %v", err)
+	}
+	buf := make([]byte, 2048)
+	for i := 0; i < 3; i++ {
+		server.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := server.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("junk packet %d not received: %v", i, err)
+		}
+		if n < 10 || n > 20 {
+			t.Errorf("junk packet %d size = %v, want between 10 and 20", i, n)
+		}
+	}
+}
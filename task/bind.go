@@ -0,0 +1,81 @@
+package task
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/netip"
+	"syscall"
+
+	"github.com/peanut996/CloudflareWarpSpeedTest/i18n"
+)
+
+var (
+	// SourceAddress is the local address probe sockets are bound to, so
+	// that probes leave through the uplink owning it.
+	SourceAddress string
+
+	// BindInterface is the network interface probe sockets are bound to.
+	BindInterface string
+
+	sourceAddr netip.Addr
+)
+
+// loadProbeSource validates the -source option.
+func loadProbeSource() {
+	if SourceAddress == "" {
+		sourceAddr = netip.Addr{}
+		return
+	}
+	var err error
+	if sourceAddr, err = netip.ParseAddr(SourceAddress); err != nil {
+		log.Fatalln(i18n.QueryI18n(i18n.SourceAddressInvalid) + err.Error())
+	}
+	sourceAddr = sourceAddr.Unmap()
+}
+
+// probeSource describes where probes leave from, as recorded in the
+// results. It is empty when they follow the default route.
+func probeSource() string {
+	switch {
+	case sourceAddr.IsValid() && BindInterface != "":
+		return sourceAddr.String() + "%" + BindInterface
+	case sourceAddr.IsValid():
+		return sourceAddr.String()
+	default:
+		return BindInterface
+	}
+}
+
+// probeControl returns the socket option hook that binds a socket to
+// BindInterface, or nil when no interface is configured.
+func probeControl() func(network, address string, c syscall.RawConn) error {
+	if BindInterface == "" {
+		return nil
+	}
+	name := BindInterface
+	return func(network, address string, c syscall.RawConn) error {
+		var bindErr error
+		if err := c.Control(func(fd uintptr) {
+			bindErr = bindToDevice(fd, name)
+		}); err != nil {
+			return err
+		}
+		return bindErr
+	}
+}
+
+// listenProbeSocket opens an unconnected UDP socket bound to the configured
+// source address and interface.
+func listenProbeSocket() (*net.UDPConn, error) {
+	var laddr string
+	if sourceAddr.IsValid() {
+		laddr = netip.AddrPortFrom(sourceAddr, 0).String()
+	}
+	lc := net.ListenConfig{Control: probeControl()}
+	conn, err := lc.ListenPacket(context.Background(), "udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
@@ -0,0 +1,7 @@
+package task
+
+import "syscall"
+
+func bindToDevice(fd uintptr, name string) error {
+	return syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, name)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package task
+
+import "errors"
+
+func bindToDevice(fd uintptr, name string) error {
+	return errors.New("binding to an interface is only supported on Linux")
+}
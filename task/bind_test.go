@@ -0,0 +1,90 @@
+package task
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+func setProbeSource(t *testing.T, source, iface string) {
+	t.Helper()
+	origSource, origIface := SourceAddress, BindInterface
+	t.Cleanup(func() {
+		SourceAddress, BindInterface = origSource, origIface
+		loadProbeSource()
+	})
+	SourceAddress, BindInterface = source, iface
+	loadProbeSource()
+}
+
+func TestProbeSource(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		iface  string
+		want   string
+	}{
+		{name: "default route", want: ""},
+		{name: "source", source: "192.0.2.1", want: "192.0.2.1"},
+		{name: "mapped source", source: "::ffff:192.0.2.1", want: "192.0.2.1"},
+		{name: "interface", iface: "eth1", want: "eth1"},
+		{name: "both", source: "2001:db8::1", iface: "eth1", want: "2001:db8::1%eth1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setProbeSource(t, tt.source, tt.iface)
+			if got := probeSource(); got != tt.want {
+				t.Errorf("probeSource() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSocketPool_Source(t *testing.T) {
+	setProbeSource(t, "127.0.0.1", "")
+	addr := startFakeResponder(t, responseTo)
+	pool := newTestSocketPool(t)
+
+	for _, sock := range pool.socks {
+		if got := sock.LocalAddr().(*net.UDPAddr).AddrPort().Addr(); got != netip.MustParseAddr("127.0.0.1") {
+			t.Errorf("socket bound to %v, want 127.0.0.1", got)
+		}
+	}
+	packet := make([]byte, device.MessageInitiationSize)
+	packet[0] = device.MessageInitiationType
+	if _, _, err := pool.conn(addr).exchange(packet, 0, time.Second); err != nil {
+		t.Errorf("exchange() error = %v", err)
+	}
+}
+
+func TestListenProbeSocket_Interface(t *testing.T) {
+	setProbeSource(t, "", "lo")
+	sock, err := listenProbeSocket()
+	if runtime.GOOS != "linux" {
+		if err == nil {
+			sock.Close()
+			t.Fatal("listenProbeSocket() should fail to bind an interface outside Linux")
+		}
+		return
+	}
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("binding to an interface needs CAP_NET_RAW on this kernel")
+	}
+	if err != nil {
+		t.Fatalf("listenProbeSocket() error = %v", err)
+	}
+	sock.Close()
+
+	setProbeSource(t, "", "no-such-interface0")
+	if sock, err := listenProbeSocket(); err == nil {
+		sock.Close()
+		t.Error("listenProbeSocket() should fail for an unknown interface")
+	}
+}
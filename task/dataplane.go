@@ -0,0 +1,208 @@
+package task
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+
+	"github.com/peanut996/CloudflareWarpSpeedTest/i18n"
+	"github.com/peanut996/CloudflareWarpSpeedTest/utils"
+)
+
+const (
+	DataPlaneICMP = "icmp"
+	DataPlaneDNS  = "dns"
+
+	defaultDataPlaneTarget = "1.1.1.1"
+	dataPlaneQueryName     = "cloudflare.com."
+	// dataPlaneAttempts probes are sent through every tunnel. The first one
+	// also carries the WireGuard handshake, so the fastest answer is kept.
+	dataPlaneAttempts = 3
+)
+
+var (
+	// DataPlaneCount is how many of the best endpoints get a data plane
+	// check; 0 disables the phase.
+	DataPlaneCount = 0
+
+	// DataPlaneMethod is DataPlaneICMP or DataPlaneDNS.
+	DataPlaneMethod = DataPlaneICMP
+
+	// DataPlaneTarget is pinged, or asked for an A record on port 53,
+	// through the tunnel.
+	DataPlaneTarget = defaultDataPlaneTarget
+
+	// dataPlaneTimeout bounds the wait for each reply.
+	dataPlaneTimeout = 2 * time.Second
+
+	errNoDataPlaneReply = errors.New("no reply through the tunnel")
+)
+
+// CheckDataPlane brings up a tunnel to the first DataPlaneCount valid
+// endpoints in ipSet and checks that traffic flows through it, recording
+// the inner round-trip time.
+func CheckDataPlane(ctx context.Context, ipSet utils.PingDelaySet) utils.PingDelaySet {
+	if DataPlaneCount <= 0 || len(ipSet) == 0 {
+		return ipSet
+	}
+	if PrivateKey == "" {
+		log.Fatalln(i18n.QueryI18n(i18n.DataPlanePrivateKeyRequired))
+	}
+	target, err := dataPlaneTarget()
+	if err != nil {
+		log.Fatalln(i18n.QueryI18n(i18n.DataPlaneTargetInvalid) + err.Error())
+	}
+	if DataPlaneMethod != DataPlaneICMP && DataPlaneMethod != DataPlaneDNS {
+		log.Fatalln(i18n.QueryI18n(i18n.DataPlaneMethodInvalid) + DataPlaneMethod)
+	}
+
+	targets := tunnelTargets(ipSet, DataPlaneCount)
+
+	bar := utils.NewBar(len(targets), i18n.QueryI18n(i18n.DataPlaneChecking), "")
+	passed := 0
+	for _, i := range targets {
+		if ctx.Err() != nil {
+			break
+		}
+		rtt, err := measureDataPlane(ctx, ipSet[i].IP, target)
+		if err == nil {
+			ipSet[i].DataPlane = utils.DataPlaneOK
+			ipSet[i].DataPlaneRTT = rtt
+			passed++
+		} else if ctx.Err() == nil {
+			ipSet[i].DataPlane = utils.DataPlaneFailed
+		}
+		bar.Grow(1, strconv.Itoa(passed))
+	}
+	bar.Done()
+	return ipSet
+}
+
+// dataPlaneTarget parses DataPlaneTarget, which must be an IPv4 address as
+// the tunnel has no IPv6 one.
+func dataPlaneTarget() (netip.Addr, error) {
+	target, err := netip.ParseAddr(DataPlaneTarget)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if target = target.Unmap(); !target.Is4() {
+		return netip.Addr{}, errors.New(target.String() + ": " + i18n.QueryI18n(i18n.DataPlaneTargetNotIPv4))
+	}
+	return target, nil
+}
+
+// measureDataPlane returns the fastest round trip to target through a
+// tunnel to endpoint.
+func measureDataPlane(ctx context.Context, endpoint *net.UDPAddr, target netip.Addr) (time.Duration, error) {
+	tun, err := newTunnel(endpoint)
+	if err != nil {
+		return 0, err
+	}
+	defer tun.Close()
+
+	var conn net.Conn
+	if DataPlaneMethod == DataPlaneDNS {
+		conn, err = tun.tnet.DialUDPAddrPort(netip.AddrPort{}, netip.AddrPortFrom(target, 53))
+	} else {
+		conn, err = tun.tnet.DialPingAddr(netip.Addr{}, target)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var best time.Duration
+	for seq := 1; seq <= dataPlaneAttempts && ctx.Err() == nil; seq++ {
+		var rtt time.Duration
+		if DataPlaneMethod == DataPlaneDNS {
+			rtt, err = queryDNS(conn)
+		} else {
+			rtt, err = ping(conn, seq)
+		}
+		if err == nil && (best == 0 || rtt < best) {
+			best = rtt
+		}
+	}
+	if best == 0 {
+		return 0, errNoDataPlaneReply
+	}
+	return best, nil
+}
+
+// ping sends an ICMP echo request and waits for the matching reply.
+func ping(conn net.Conn, seq int) (time.Duration, error) {
+	payload := make([]byte, 16)
+	rand.Read(payload)
+	request, err := (&icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: 1, Seq: seq, Data: payload},
+	}).Marshal(nil)
+	if err != nil {
+		return 0, err
+	}
+	return roundTrip(conn, request, func(reply []byte) bool {
+		msg, err := icmp.ParseMessage(ipv4.ICMPTypeEchoReply.Protocol(), reply)
+		if err != nil || msg.Type != ipv4.ICMPTypeEchoReply {
+			return false
+		}
+		echo, ok := msg.Body.(*icmp.Echo)
+		return ok && echo.Seq == seq && string(echo.Data) == string(payload)
+	})
+}
+
+// queryDNS asks for the A record of dataPlaneQueryName and waits for the
+// matching response.
+func queryDNS(conn net.Conn) (time.Duration, error) {
+	var id [2]byte
+	rand.Read(id[:])
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:]), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName(dataPlaneQueryName),
+			Type:  dnsmessage.TypeA,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	request, err := query.Pack()
+	if err != nil {
+		return 0, err
+	}
+	return roundTrip(conn, request, func(reply []byte) bool {
+		var parser dnsmessage.Parser
+		header, err := parser.Start(reply)
+		return err == nil && header.Response && header.ID == query.ID
+	})
+}
+
+// roundTrip writes request and reads until match accepts a reply or the
+// timeout expires.
+func roundTrip(conn net.Conn, request []byte, match func([]byte) bool) (time.Duration, error) {
+	if err := conn.SetDeadline(time.Now().Add(dataPlaneTimeout)); err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %v", errNoDataPlaneReply, err)
+		}
+		if match(buf[:n]) {
+			return time.Since(start), nil
+		}
+	}
+}
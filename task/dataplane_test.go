@@ -0,0 +1,182 @@
+package task
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+
+	"github.com/peanut996/CloudflareWarpSpeedTest/utils"
+)
+
+// serveDNS answers every query sent to 10.9.0.1:53 on tnet with an empty
+// response.
+func serveDNS(t *testing.T, tnet *netstack.Net) {
+	t.Helper()
+	conn, err := tnet.ListenUDPAddrPort(netip.MustParseAddrPort("10.9.0.1:53"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var parser dnsmessage.Parser
+			header, err := parser.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			reply, _ := (&dnsmessage.Message{Header: dnsmessage.Header{ID: header.ID, Response: true}}).Pack()
+			conn.WriteTo(reply, from)
+		}
+	}()
+}
+
+func TestMeasureDataPlane(t *testing.T) {
+	origMethod, origTimeout := DataPlaneMethod, dataPlaneTimeout
+	defer func() { DataPlaneMethod, dataPlaneTimeout = origMethod, origTimeout }()
+	dataPlaneTimeout = 300 * time.Millisecond
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		wantErr bool
+	}{
+		{name: "icmp", method: DataPlaneICMP, target: "10.9.0.1"},
+		{name: "dns", method: DataPlaneDNS, target: "10.9.0.1"},
+		{name: "icmp unreachable", method: DataPlaneICMP, target: "10.9.0.3", wantErr: true},
+		{name: "dns no server", method: DataPlaneDNS, target: "10.9.0.3", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a fresh peer each time, as WireGuard ignores handshakes
+			// initiated in quick succession by the same peer
+			endpoint, tnet := startTestPeer(t, http.NotFoundHandler())
+			serveDNS(t, tnet)
+			DataPlaneMethod = tt.method
+			rtt, err := measureDataPlane(context.Background(), endpoint, netip.MustParseAddr(tt.target))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("measureDataPlane() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && rtt <= 0 {
+				t.Errorf("measureDataPlane() rtt = %v, want > 0", rtt)
+			}
+		})
+	}
+}
+
+func TestMeasureDataPlane_ProbePath(t *testing.T) {
+	origMethod, origTimeout := DataPlaneMethod, dataPlaneTimeout
+	defer func() { DataPlaneMethod, dataPlaneTimeout = origMethod, origTimeout }()
+	DataPlaneMethod, dataPlaneTimeout = DataPlaneICMP, 300*time.Millisecond
+
+	// a listener that is closed right away leaves a port nothing answers on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadProxy := ln.Addr().String()
+	ln.Close()
+
+	tests := []struct {
+		name    string
+		source  string
+		proxy   string
+		wantErr bool
+	}{
+		{name: "source", source: "127.0.0.1"},
+		{name: "socks5", proxy: startSocksServer(t, "", "")},
+		{name: "socks5 down", proxy: deadProxy, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setProbeSource(t, tt.source, "")
+			setSocksProxy(t, tt.proxy)
+			endpoint, _ := startTestPeer(t, http.NotFoundHandler())
+			_, err := measureDataPlane(context.Background(), endpoint, netip.MustParseAddr("10.9.0.1"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("measureDataPlane() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestQueryDNS_IgnoresStaleReplies(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		buf := make([]byte, 512)
+		n, err := server.Read(buf)
+		if err != nil {
+			return
+		}
+		var parser dnsmessage.Parser
+		header, err := parser.Start(buf[:n])
+		if err != nil {
+			return
+		}
+		stale, _ := (&dnsmessage.Message{Header: dnsmessage.Header{ID: header.ID + 1, Response: true}}).Pack()
+		server.Write(stale)
+		query, _ := (&dnsmessage.Message{Header: dnsmessage.Header{ID: header.ID}}).Pack()
+		server.Write(query)
+		reply, _ := (&dnsmessage.Message{Header: dnsmessage.Header{ID: header.ID, Response: true}}).Pack()
+		server.Write(reply)
+	}()
+
+	if _, err := queryDNS(client); err != nil {
+		t.Fatalf("queryDNS() error = %v", err)
+	}
+}
+
+func TestCheckDataPlane_Disabled(t *testing.T) {
+	origCount := DataPlaneCount
+	defer func() { DataPlaneCount = origCount }()
+	DataPlaneCount = 0
+
+	ipSet := utils.PingDelaySet{{PingData: &utils.PingData{IP: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2408}}}}
+	got := CheckDataPlane(context.Background(), ipSet)
+	if got[0].DataPlane != utils.DataPlaneUnchecked {
+		t.Errorf("CheckDataPlane() status = %v, want unchecked", got[0].DataPlane)
+	}
+}
+
+func TestDataPlaneTarget(t *testing.T) {
+	orig := DataPlaneTarget
+	defer func() { DataPlaneTarget = orig }()
+
+	tests := []struct {
+		target  string
+		want    string
+		wantErr bool
+	}{
+		{target: "1.1.1.1", want: "1.1.1.1"},
+		{target: "::ffff:1.0.0.1", want: "1.0.0.1"},
+		{target: "2606:4700:4700::1111", wantErr: true},
+		{target: "one.one.one.one", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			DataPlaneTarget = tt.target
+			got, err := dataPlaneTarget()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("dataPlaneTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.String() != tt.want {
+				t.Errorf("dataPlaneTarget() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
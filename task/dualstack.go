@@ -0,0 +1,71 @@
+package task
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/peanut996/CloudflareWarpSpeedTest/i18n"
+)
+
+// DualStackMode scans the built-in IPv4 and IPv6 ranges in one run.
+var DualStackMode = false
+
+// ipv6ProbeTarget is only used to select a route; nothing is sent to it.
+var ipv6ProbeTarget = "[2606:4700:4700::1111]:53"
+
+// hasIPv6 reports whether probes have a global IPv6 route. Connecting a
+// UDP socket makes the kernel pick the route and source address without
+// sending any packet. Behind a SOCKS5 proxy the relay decides, so IPv6 is
+// assumed to work.
+func hasIPv6() bool {
+	if proxy != nil {
+		return true
+	}
+	dialer := net.Dialer{Control: probeControl()}
+	if sourceAddr.IsValid() {
+		if sourceAddr.Is4() {
+			return false
+		}
+		dialer.LocalAddr = net.UDPAddrFromAddrPort(netip.AddrPortFrom(sourceAddr, 0))
+	}
+	conn, err := dialer.Dial("udp6", ipv6ProbeTarget)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	ip := conn.LocalAddr().(*net.UDPAddr).IP
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// limitDualStack drops IPv6 endpoints when the host cannot reach them and
+// splits MaxScanCount between both families, so that the much larger IPv6
+// range does not crowd out IPv4.
+func limitDualStack(addrs []*UDPAddr, ipv6 bool) []*UDPAddr {
+	var v4, v6 []*UDPAddr
+	for _, addr := range addrs {
+		if addr.IP.IP.To4() != nil {
+			v4 = append(v4, addr)
+		} else if ipv6 {
+			v6 = append(v6, addr)
+		}
+	}
+	if !AllMode && len(v4)+len(v6) > MaxScanCount {
+		n4 := min(len(v4), max(MaxScanCount/2, MaxScanCount-len(v6)))
+		n6 := min(len(v6), MaxScanCount-n4)
+		v4, v6 = v4[:n4], v6[:n6]
+	}
+	addrs = append(v4, v6...)
+	shuffleAddrs(&addrs)
+	return addrs
+}
+
+// loadDualStack checks IPv6 connectivity once and limits addrs for a
+// dual-stack scan.
+func loadDualStack(addrs []*UDPAddr) []*UDPAddr {
+	ipv6 := hasIPv6()
+	if !ipv6 {
+		fmt.Println(i18n.QueryI18n(i18n.IPv6Unavailable))
+	}
+	return limitDualStack(addrs, ipv6)
+}
@@ -0,0 +1,66 @@
+package task
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestHasIPv6_Loopback(t *testing.T) {
+	orig := ipv6ProbeTarget
+	defer func() { ipv6ProbeTarget = orig }()
+	ipv6ProbeTarget = "[::1]:53"
+
+	if hasIPv6() {
+		t.Error("hasIPv6() = true for a loopback route, want false")
+	}
+}
+
+func TestLimitDualStack(t *testing.T) {
+	origCount, origAll := MaxScanCount, AllMode
+	defer func() { MaxScanCount, AllMode = origCount, origAll }()
+	AllMode = false
+
+	addrs := func(v4, v6 int) []*UDPAddr {
+		var out []*UDPAddr
+		for i := 0; i < v4; i++ {
+			out = append(out, &UDPAddr{IP: &net.IPAddr{IP: net.IPv4(162, 159, 192, byte(i))}, Port: 2408})
+		}
+		for i := 0; i < v6; i++ {
+			out = append(out, &UDPAddr{IP: &net.IPAddr{IP: net.ParseIP(fmt.Sprintf("2606:4700:d0::%x", i))}, Port: 2408})
+		}
+		return out
+	}
+
+	tests := []struct {
+		name     string
+		v4, v6   int
+		maxCount int
+		ipv6     bool
+		want4    int
+		want6    int
+	}{
+		{name: "even split", v4: 100, v6: 100, maxCount: 50, ipv6: true, want4: 25, want6: 25},
+		{name: "few ipv4", v4: 10, v6: 100, maxCount: 50, ipv6: true, want4: 10, want6: 40},
+		{name: "few ipv6", v4: 100, v6: 10, maxCount: 50, ipv6: true, want4: 40, want6: 10},
+		{name: "under limit", v4: 10, v6: 10, maxCount: 50, ipv6: true, want4: 10, want6: 10},
+		{name: "no ipv6 route", v4: 100, v6: 100, maxCount: 50, ipv6: false, want4: 50, want6: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			MaxScanCount = tt.maxCount
+			var got4, got6 int
+			for _, addr := range limitDualStack(addrs(tt.v4, tt.v6), tt.ipv6) {
+				if addr.IP.IP.To4() != nil {
+					got4++
+				} else {
+					got6++
+				}
+			}
+			if got4 != tt.want4 || got6 != tt.want6 {
+				t.Errorf("limitDualStack() = %d IPv4, %d IPv6, want %d, %d", got4, got6, tt.want4, tt.want6)
+			}
+		})
+	}
+}
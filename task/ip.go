@@ -2,9 +2,15 @@ package task
 
 import (
 	"bufio"
+	crand "crypto/rand"
+	"errors"
+	"fmt"
 	"log"
+	"math"
+	"math/big"
 	"math/rand/v2"
 	"net"
+	"net/netip"
 	"os"
 	"strconv"
 	"strings"
@@ -13,8 +19,9 @@ import (
 )
 
 var (
-	IPText string
-	IPFile string
+	IPText   string
+	IPFile   string
+	PortText string
 )
 
 func isIPv4(ip string) bool {
@@ -29,7 +36,9 @@ func randIPEndWith(num byte) byte {
 }
 
 type IPRanges struct {
-	ips     []*net.IPAddr
+	ips []*net.IPAddr
+	// addrs holds endpoints of entries that carry their own ports
+	addrs   []*UDPAddr
 	mask    string
 	firstIP net.IP
 	ipNet   *net.IPNet
@@ -55,11 +64,10 @@ func (r *IPRanges) fixIP(ip string) string {
 	return ip
 }
 
-func (r *IPRanges) parseCIDR(ip string) {
+func (r *IPRanges) parseCIDR(ip string) error {
 	var err error
-	if r.firstIP, r.ipNet, err = net.ParseCIDR(r.fixIP(ip)); err != nil {
-		log.Fatalln(i18n.QueryI18n(i18n.CidrInvalid), err)
-	}
+	r.firstIP, r.ipNet, err = net.ParseCIDR(r.fixIP(ip))
+	return err
 }
 
 func (r *IPRanges) appendIPv4(d byte) {
@@ -130,22 +138,198 @@ func (r *IPRanges) chooseIPv6() {
 	}
 }
 
-func loadIPRanges() []*net.IPAddr {
-	ipRanges := newIPRanges()
-	if IPText != "" {
-		IPs := strings.Split(IPText, ",")
-		for _, IP := range IPs {
-			IP = strings.TrimSpace(IP)
-			if IP == "" {
-				continue
+// appendEntry expands one target entry: an IP, a CIDR or an a.b.c.d-a.b.c.e
+// range, optionally followed by :port or :port-range. IPv6 entries with a
+// port are written as [2606:4700:d0::1]:2408. Entries with ports become
+// endpoints of their own instead of being crossed with the port table.
+func (r *IPRanges) appendEntry(entry string) error {
+	host, portSpec, err := splitEntry(entry)
+	if err != nil {
+		return err
+	}
+	start := len(r.ips)
+	if strings.Contains(host, "-") {
+		err = r.appendRange(host)
+	} else {
+		err = r.appendCIDR(host)
+	}
+	if err != nil {
+		return err
+	}
+	if portSpec == "" {
+		return nil
+	}
+	entryPorts, err := parsePorts(portSpec)
+	if err != nil {
+		return err
+	}
+	for _, port := range entryPorts {
+		r.addrs = append(r.addrs, generateSingleIPAddr(r.ips[start:], port)...)
+	}
+	r.ips = r.ips[:start]
+	return nil
+}
+
+func (r *IPRanges) appendCIDR(ip string) error {
+	if err := r.parseCIDR(ip); err != nil {
+		return err
+	}
+	if isIPv4(ip) {
+		r.chooseIPv4()
+	} else {
+		r.chooseIPv6()
+	}
+	return nil
+}
+
+func (r *IPRanges) appendRange(ipRange string) error {
+	from, to, _ := strings.Cut(ipRange, "-")
+	first, err := netip.ParseAddr(strings.TrimSpace(from))
+	if err != nil {
+		return err
+	}
+	last, err := netip.ParseAddr(strings.TrimSpace(to))
+	if err != nil {
+		return err
+	}
+	if first.Is4() != last.Is4() || last.Less(first) {
+		return fmt.Errorf("invalid IP range %s", ipRange)
+	}
+	// ranges with more addresses than are scanned are sampled like IPv6
+	// CIDRs, except that -all expands IPv4 ranges like IPv4 CIDRs
+	limit := max(MaxScanCount, 1)
+	if AllMode && first.Is4() {
+		limit = math.MaxInt
+	}
+	start := len(r.ips)
+	for ip := first; ; ip = ip.Next() {
+		if len(r.ips)-start == limit {
+			r.ips = r.ips[:start]
+			return r.sampleRange(first, last, limit)
+		}
+		r.appendIP(net.IP(ip.AsSlice()))
+		if ip == last {
+			return nil
+		}
+	}
+}
+
+// sampleRange appends n distinct random addresses from first to last, which
+// must hold more than n addresses.
+func (r *IPRanges) sampleRange(first, last netip.Addr, n int) error {
+	low := new(big.Int).SetBytes(first.AsSlice())
+	size := new(big.Int).SetBytes(last.AsSlice())
+	size.Sub(size, low).Add(size, big.NewInt(1))
+	seen := make(map[netip.Addr]bool, n)
+	for len(seen) < n {
+		offset, err := crand.Int(crand.Reader, size)
+		if err != nil {
+			return err
+		}
+		ip, _ := netip.AddrFromSlice(offset.Add(offset, low).FillBytes(make([]byte, first.BitLen()/8)))
+		if !seen[ip] {
+			seen[ip] = true
+			r.appendIP(net.IP(ip.AsSlice()))
+		}
+	}
+	return nil
+}
+
+// splitEntry separates the address part of a target entry from its port
+// spec, which is empty if the entry has none.
+func splitEntry(entry string) (host, portSpec string, err error) {
+	if strings.HasPrefix(entry, "[") {
+		end := strings.IndexByte(entry, ']')
+		if end < 0 {
+			return "", "", fmt.Errorf("missing ] in %s", entry)
+		}
+		host, rest := entry[1:end], entry[end+1:]
+		if rest == "" {
+			return host, "", nil
+		}
+		if rest[0] != ':' || len(rest) == 1 {
+			return "", "", fmt.Errorf("invalid port in %s", entry)
+		}
+		return host, rest[1:], nil
+	}
+	if isIPv4(entry) {
+		host, portSpec, found := strings.Cut(entry, ":")
+		if found && portSpec == "" {
+			return "", "", fmt.Errorf("invalid port in %s", entry)
+		}
+		return host, portSpec, nil
+	}
+	// an IPv6 CIDR can carry a port after its mask without brackets
+	if slash := strings.IndexByte(entry, '/'); slash >= 0 {
+		if colon := strings.IndexByte(entry[slash:], ':'); colon >= 0 {
+			return entry[:slash+colon], entry[slash+colon+1:], nil
+		}
+	}
+	return entry, "", nil
+}
+
+// parsePorts parses a comma separated list of ports and port ranges such as
+// 500,2408,4500-4510. Duplicates are dropped.
+func parsePorts(s string) ([]int, error) {
+	var result []int
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := parsePort(from)
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if isRange {
+			if last, err = parsePort(to); err != nil {
+				return nil, err
 			}
-			ipRanges.parseCIDR(IP)
-			if isIPv4(IP) {
-				ipRanges.chooseIPv4()
-			} else {
-				ipRanges.chooseIPv6()
+		}
+		if last < first {
+			return nil, fmt.Errorf("invalid port range %s", part)
+		}
+		for port := first; port <= last; port++ {
+			if !seen[port] {
+				seen[port] = true
+				result = append(result, port)
 			}
 		}
+	}
+	if len(result) == 0 {
+		return nil, errors.New("no port given")
+	}
+	return result, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+// loadPorts replaces the built-in port table with the -port list.
+func loadPorts() {
+	if PortText == "" {
+		return
+	}
+	var err error
+	if ports, err = parsePorts(PortText); err != nil {
+		log.Fatalln(i18n.QueryI18n(i18n.PortInvalid) + err.Error())
+	}
+}
+
+// loadIPRanges returns the IPs to be crossed with the port table and the
+// endpoints given with explicit ports.
+func loadIPRanges() ([]*net.IPAddr, []*UDPAddr) {
+	var entries []string
+	if IPText != "" {
+		entries = strings.Split(IPText, ",")
 	} else if IPFile != "" {
 		file, err := os.Open(IPFile)
 		if err != nil {
@@ -154,30 +338,25 @@ func loadIPRanges() []*net.IPAddr {
 		defer file.Close()
 		scanner := bufio.NewScanner(file)
 		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if line == "" {
-				continue
-			}
-			ipRanges.parseCIDR(line)
-			if isIPv4(line) {
-				ipRanges.chooseIPv4()
-			} else {
-				ipRanges.chooseIPv6()
-			}
+			entries = append(entries, scanner.Text())
 		}
+	} else if v4, v6 := defaultCIDRs(); DualStackMode {
+		entries = append(append(entries, v4...), v6...)
+	} else if IPv6Mode {
+		entries = v6
 	} else {
-		cidrRanges := commonIPv4CIDRs
-		if IPv6Mode {
-			cidrRanges = commonIPv6CIDRs
-		}
-		for _, cidr := range cidrRanges {
-			ipRanges.parseCIDR(cidr)
-			if isIPv4(cidr) {
-				ipRanges.chooseIPv4()
-			} else {
-				ipRanges.chooseIPv6()
-			}
+		entries = v4
+	}
+
+	ipRanges := newIPRanges()
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if err := ipRanges.appendEntry(entry); err != nil {
+			log.Fatalln(i18n.QueryI18n(i18n.IPEntryInvalid) + err.Error())
 		}
 	}
-	return ipRanges.ips
+	return ipRanges.ips, ipRanges.addrs
 }
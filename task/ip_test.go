@@ -2,6 +2,9 @@ package task
 
 import (
 	"net"
+	"net/netip"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -135,3 +138,124 @@ func TestIPRanges_AppendIP(t *testing.T) {
 		t.Errorf("appendIP() appended wrong IP, got %v, want %v", r.ips[0].IP, testIP)
 	}
 }
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []int
+		wantErr bool
+	}{
+		{name: "single", s: "2408", want: []int{2408}},
+		{name: "list and range", s: "500,2408,4500-4502", want: []int{500, 2408, 4500, 4501, 4502}},
+		{name: "duplicates dropped", s: "500, 500-501", want: []int{500, 501}},
+		{name: "reversed range", s: "4510-4500", wantErr: true},
+		{name: "out of range", s: "65536", wantErr: true},
+		{name: "not a number", s: "abc", wantErr: true},
+		{name: "empty", s: " , ", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePorts(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePorts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePorts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPRanges_AppendEntry(t *testing.T) {
+	tests := []struct {
+		name      string
+		entry     string
+		wantIPs   []string
+		wantAddrs []string
+		wantErr   bool
+	}{
+		{name: "ipv4", entry: "162.159.192.1", wantIPs: []string{"162.159.192.1"}},
+		{name: "ipv4 with port", entry: "162.159.192.1:2408", wantAddrs: []string{"162.159.192.1:2408"}},
+		{name: "ipv6 with port", entry: "[2606:4700:d0::1]:500", wantAddrs: []string{"[2606:4700:d0::1]:500"}},
+		{name: "bracketed ipv6 without port", entry: "[2606:4700:d0::1]", wantIPs: []string{"2606:4700:d0::1"}},
+		{name: "ipv4 range", entry: "162.159.192.254-162.159.193.1", wantIPs: []string{"162.159.192.254", "162.159.192.255", "162.159.193.0", "162.159.193.1"}},
+		{name: "ipv6 range with port", entry: "[2606:4700:d0::1-2606:4700:d0::2]:500", wantAddrs: []string{"[2606:4700:d0::1]:500", "[2606:4700:d0::2]:500"}},
+		{name: "cidr with port range", entry: "162.159.192.0/31:500-501", wantAddrs: []string{"162.159.192.0:500", "162.159.192.1:500", "162.159.192.0:501", "162.159.192.1:501"}},
+		{name: "reversed range", entry: "162.159.192.2-162.159.192.1", wantErr: true},
+		{name: "mixed family range", entry: "162.159.192.1-2606:4700:d0::1", wantErr: true},
+		{name: "missing port", entry: "162.159.192.1:", wantErr: true},
+		{name: "invalid port", entry: "[2606:4700:d0::1]:0", wantErr: true},
+		{name: "invalid ip", entry: "162.159.192.300", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newIPRanges()
+			err := r.appendEntry(tt.entry)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("appendEntry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var gotIPs, gotAddrs []string
+			for _, ip := range r.ips {
+				gotIPs = append(gotIPs, ip.String())
+			}
+			for _, addr := range r.addrs {
+				gotAddrs = append(gotAddrs, addr.FullAddress())
+			}
+			if !reflect.DeepEqual(gotIPs, tt.wantIPs) {
+				t.Errorf("appendEntry() ips = %v, want %v", gotIPs, tt.wantIPs)
+			}
+			if !reflect.DeepEqual(gotAddrs, tt.wantAddrs) {
+				t.Errorf("appendEntry() addrs = %v, want %v", gotAddrs, tt.wantAddrs)
+			}
+		})
+	}
+}
+
+func TestIPRanges_AppendEntry_LargeRange(t *testing.T) {
+	origCount, origAll := MaxScanCount, AllMode
+	defer func() { MaxScanCount, AllMode = origCount, origAll }()
+	MaxScanCount, AllMode = 100, false
+
+	tests := []struct {
+		name    string
+		entry   string
+		all     bool
+		wantLen int
+	}{
+		{name: "ipv6 range", entry: "2606:4700::-2606:4700:ffff::", wantLen: 100},
+		{name: "ipv6 range with all", entry: "2606:4700::-2606:4700:ffff::", all: true, wantLen: 100},
+		{name: "ipv4 range", entry: "10.0.0.0-10.255.255.255", wantLen: 100},
+		{name: "ipv4 range with all", entry: "162.159.192.0-162.159.193.255", all: true, wantLen: 512},
+		{name: "range of the scan size", entry: "162.159.192.0-162.159.192.99", wantLen: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			AllMode = tt.all
+			from, to, _ := strings.Cut(tt.entry, "-")
+			first, last := netip.MustParseAddr(from), netip.MustParseAddr(to)
+			r := newIPRanges()
+			if err := r.appendEntry(tt.entry); err != nil {
+				t.Fatalf("appendEntry() error = %v", err)
+			}
+			if len(r.ips) != tt.wantLen {
+				t.Fatalf("appendEntry() expanded %d addresses, want %d", len(r.ips), tt.wantLen)
+			}
+			seen := make(map[netip.Addr]bool)
+			for _, ip := range r.ips {
+				addr, _ := netip.AddrFromSlice(ip.IP)
+				addr = addr.Unmap()
+				if addr.Less(first) || last.Less(addr) || seen[addr] {
+					t.Errorf("appendEntry() address %v is outside the range or repeated", addr)
+				}
+				seen[addr] = true
+			}
+		})
+	}
+}
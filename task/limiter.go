@@ -0,0 +1,133 @@
+package task
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// limiterBurstWindow is how much of a second's packet budget may be sent
+	// back to back.
+	limiterBurstWindow = 10
+	ipv4PrefixBits     = 24
+	ipv6PrefixBits     = 48
+)
+
+var (
+	// PacketRate caps the packets sent per second by all probes. 0 means
+	// unlimited.
+	PacketRate = 0
+
+	// PrefixConcurrency caps the endpoints probed at the same time within
+	// one /24 (IPv4) or /48 (IPv6). 0 means unlimited.
+	PrefixConcurrency = 0
+)
+
+// newPacketLimiter returns the token bucket shared by all probes, or nil if
+// the packet rate is unlimited. The burst always fits one probe with its
+// junk packets.
+func newPacketLimiter() *rate.Limiter {
+	if PacketRate <= 0 {
+		return nil
+	}
+	burst := max(PacketRate/limiterBurstWindow, probePackets())
+	return rate.NewLimiter(rate.Limit(PacketRate), burst)
+}
+
+// probePackets is the number of datagrams sent for one handshake.
+func probePackets() int {
+	return 1 + JunkPacketCount
+}
+
+// throttle blocks until n packets may be sent and returns how long it
+// waited. It fails if ctx is done first.
+func throttle(ctx context.Context, limiter *rate.Limiter, n int) (time.Duration, error) {
+	if limiter == nil {
+		return 0, nil
+	}
+	reservation := limiter.ReserveN(time.Now(), n)
+	delay := reservation.Delay()
+	if delay == 0 {
+		return 0, nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		reservation.Cancel()
+		return 0, ctx.Err()
+	}
+}
+
+// prefixLimiter bounds the concurrent probes per network prefix.
+type prefixLimiter struct {
+	m     sync.Mutex
+	limit int
+	slots map[string]chan struct{}
+}
+
+// newPrefixLimiter returns nil if the per-prefix concurrency is unlimited.
+func newPrefixLimiter() *prefixLimiter {
+	if PrefixConcurrency <= 0 {
+		return nil
+	}
+	return &prefixLimiter{
+		limit: PrefixConcurrency,
+		slots: make(map[string]chan struct{}),
+	}
+}
+
+// acquire blocks until ip's prefix has a free slot and returns how long it
+// waited. The slot must be given back with release unless ctx is done
+// first.
+func (l *prefixLimiter) acquire(ctx context.Context, ip net.IP) (time.Duration, error) {
+	if l == nil {
+		return 0, nil
+	}
+	slot := l.slot(ip)
+	select {
+	case slot <- struct{}{}:
+		return 0, nil
+	default:
+	}
+	start := time.Now()
+	select {
+	case slot <- struct{}{}:
+		return time.Since(start), nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func (l *prefixLimiter) release(ip net.IP) {
+	if l == nil {
+		return
+	}
+	<-l.slot(ip)
+}
+
+func (l *prefixLimiter) slot(ip net.IP) chan struct{} {
+	key := prefixKey(ip)
+	l.m.Lock()
+	defer l.m.Unlock()
+	slot, ok := l.slots[key]
+	if !ok {
+		slot = make(chan struct{}, l.limit)
+		l.slots[key] = slot
+	}
+	return slot
+}
+
+// prefixKey returns the /24 or /48 network ip belongs to.
+func prefixKey(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(ipv4PrefixBits, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(ipv6PrefixBits, 128)).String()
+}
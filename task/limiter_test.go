@@ -0,0 +1,113 @@
+package task
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrefixKey(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		want string
+	}{
+		{name: "ipv4", ip: "162.159.192.17", want: "162.159.192.0"},
+		{name: "ipv6", ip: "2606:4700:d0::a29f:c001", want: "2606:4700:d0::"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := prefixKey(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("prefixKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrefixLimiter(t *testing.T) {
+	orig := PrefixConcurrency
+	defer func() { PrefixConcurrency = orig }()
+	PrefixConcurrency = 1
+	l := newPrefixLimiter()
+
+	a := net.ParseIP("162.159.192.1")
+	b := net.ParseIP("162.159.192.2")
+	other := net.ParseIP("162.159.193.1")
+
+	ctx := context.Background()
+	if waited, _ := l.acquire(ctx, a); waited != 0 {
+		t.Errorf("acquire() on a free prefix waited %v", waited)
+	}
+	if waited, _ := l.acquire(ctx, other); waited != 0 {
+		t.Errorf("acquire() on another prefix waited %v", waited)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := l.acquire(cancelled, b); err == nil {
+		t.Error("acquire() on a busy prefix should fail once ctx is done")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var waited time.Duration
+	go func() {
+		defer wg.Done()
+		waited, _ = l.acquire(ctx, b)
+		l.release(b)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	l.release(a)
+	wg.Wait()
+	if waited < 40*time.Millisecond {
+		t.Errorf("acquire() on a busy prefix waited %v, want about 50ms", waited)
+	}
+	l.release(other)
+}
+
+func TestThrottle(t *testing.T) {
+	origRate, origJunk := PacketRate, JunkPacketCount
+	defer func() { PacketRate, JunkPacketCount = origRate, origJunk }()
+
+	PacketRate = 0
+	if newPacketLimiter() != nil {
+		t.Fatal("newPacketLimiter() should be nil when unlimited")
+	}
+	ctx := context.Background()
+	if waited, _ := throttle(ctx, nil, 1); waited != 0 {
+		t.Errorf("throttle() without limiter waited %v", waited)
+	}
+
+	PacketRate, JunkPacketCount = 100, 3
+	limiter := newPacketLimiter()
+	if limiter.Burst() != 10 {
+		t.Errorf("newPacketLimiter() burst = %v, want 10", limiter.Burst())
+	}
+	var total time.Duration
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		waited, err := throttle(ctx, limiter, probePackets())
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += waited
+	}
+	// 20 packets with a burst of 10 at 100 pps take at least 100ms. The
+	// waits add up to less when timers fire late, as the bucket refills
+	// meanwhile.
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("throttle() took %v in total, want at least 100ms", elapsed)
+	}
+	if total <= 0 {
+		t.Errorf("throttle() waited %v in total, want the time spent blocked", total)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := throttle(cancelled, limiter, probePackets()); err == nil {
+		t.Error("throttle() on an empty bucket should fail once ctx is done")
+	}
+}
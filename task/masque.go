@@ -0,0 +1,221 @@
+package task
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/time/rate"
+
+	"github.com/peanut996/CloudflareWarpSpeedTest/i18n"
+)
+
+const (
+	ProbeModeWireGuard = "wireguard"
+	ProbeModeMasque    = "masque"
+
+	defaultMasqueSNI    = "consumer-masque.cloudflareclient.com"
+	defaultMasqueTarget = "1.1.1.1:53"
+	// masqueUDPPath is the default URI template of RFC 9298 with the
+	// target host and port filled in.
+	masqueUDPPath = "/.well-known/masque/udp/%s/%d/"
+)
+
+var (
+	// ProbeMode selects the handshake used to probe endpoints.
+	ProbeMode = ProbeModeWireGuard
+
+	// MasqueSNI is the TLS server name and HTTP authority of MASQUE probes.
+	MasqueSNI = defaultMasqueSNI
+
+	// MasqueTarget is the UDP destination requested with CONNECT-UDP.
+	MasqueTarget = defaultMasqueTarget
+
+	// masqueRootCAs verifies MASQUE servers; nil uses the system roots.
+	masqueRootCAs *x509.CertPool
+
+	masqueTarget netip.AddrPort
+
+	masquePorts = []int{443, 500, 1701, 4443, 4500, 8095, 8443}
+
+	masqueIPv4CIDRs = []string{
+		"162.159.197.0/24",
+		"162.159.198.0/24",
+	}
+
+	masqueIPv6CIDRs = []string{
+		"2606:4700:102::/48",
+		"2606:4700:103::/48",
+	}
+
+	errMasqueUnsupported = errors.New("server does not support CONNECT-UDP")
+)
+
+// loadProbeMode validates the -mode option and the MASQUE settings, and
+// switches to the MASQUE port table unless -port is given.
+func loadProbeMode() {
+	switch ProbeMode {
+	case ProbeModeWireGuard:
+		return
+	case ProbeModeMasque:
+	default:
+		log.Fatalln(i18n.QueryI18n(i18n.ProbeModeInvalid) + ProbeMode)
+	}
+	if SocksProxy != "" {
+		log.Fatalln(i18n.QueryI18n(i18n.MasqueProxyUnsupported))
+	}
+	var err error
+	if masqueTarget, err = netip.ParseAddrPort(MasqueTarget); err != nil {
+		log.Fatalln(i18n.QueryI18n(i18n.MasqueTargetInvalid) + err.Error())
+	}
+	if PortText == "" {
+		ports = masquePorts
+	}
+}
+
+// defaultCIDRs returns the built-in ranges of the probe mode.
+func defaultCIDRs() (v4, v6 []string) {
+	if ProbeMode == ProbeModeMasque {
+		return masqueIPv4CIDRs, masqueIPv6CIDRs
+	}
+	return commonIPv4CIDRs, commonIPv6CIDRs
+}
+
+// masqueProber opens QUIC connections from a single socket and measures
+// the HTTP/3 CONNECT-UDP setup on each.
+type masqueProber struct {
+	tr      *quic.Transport
+	tlsConf *tls.Config
+}
+
+func newMasqueProber() (*masqueProber, error) {
+	conn, err := listenProbeSocket()
+	if err != nil {
+		return nil, err
+	}
+	return &masqueProber{
+		tr: &quic.Transport{Conn: conn},
+		tlsConf: &tls.Config{
+			ServerName: MasqueSNI,
+			NextProtos: []string{http3.NextProtoH3},
+			RootCAs:    masqueRootCAs,
+		},
+	}, nil
+}
+
+func (m *masqueProber) Close() {
+	m.tr.Close()
+	m.tr.Conn.Close()
+}
+
+// handshake completes a QUIC handshake with addr and opens a CONNECT-UDP
+// tunnel to MasqueTarget, each within timeout. The rtt is the QUIC
+// handshake time and connect the CONNECT-UDP request time. It only fails
+// if ctx is done before the probe could be sent.
+func (m *masqueProber) handshake(ctx context.Context, addr netip.AddrPort, limiter *rate.Limiter, timeout time.Duration) (result probeResult, err error) {
+	waited, err := throttle(ctx, limiter, 1)
+	if err != nil {
+		return result, err
+	}
+	result.waited = waited
+
+	dialCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	conn, err := m.tr.Dial(dialCtx, net.UDPAddrFromAddrPort(addr), m.tlsConf, &quic.Config{
+		EnableDatagrams:      true,
+		HandshakeIdleTimeout: timeout,
+	})
+	if err != nil {
+		if !isQUICTimeout(err) {
+			// the endpoint answered, but not with a usable handshake
+			result.status = probeInvalid
+		}
+		return result, nil
+	}
+	defer conn.CloseWithError(0, "")
+	rtt := time.Since(start)
+
+	connectCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	client := (&http3.Transport{EnableDatagrams: true}).NewClientConn(conn)
+	connect, err := connectUDP(connectCtx, client)
+	if err != nil {
+		result.status = probeInvalid
+		return result, nil
+	}
+	result.status, result.rtt, result.connect = probeOK, rtt, connect
+	return result, nil
+}
+
+func isQUICTimeout(err error) bool {
+	var idle *quic.IdleTimeoutError
+	var handshake *quic.HandshakeTimeoutError
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &idle) || errors.As(err, &handshake)
+}
+
+// connectUDP sends a CONNECT-UDP request to MasqueTarget on client and
+// returns the time until its successful response.
+func connectUDP(ctx context.Context, client *http3.ClientConn) (time.Duration, error) {
+	// Extended CONNECT may only be used once the server enabled it
+	select {
+	case <-client.ReceivedSettings():
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	if settings := client.Settings(); !settings.EnableExtendedConnect || !settings.EnableDatagrams {
+		return 0, errMasqueUnsupported
+	}
+	str, err := client.OpenRequestStream(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer str.CancelRead(0)
+	defer str.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		str.SetDeadline(deadline)
+	}
+
+	start := time.Now()
+	err = str.SendRequestHeader(&http.Request{
+		Method: http.MethodConnect,
+		Proto:  "connect-udp",
+		Host:   MasqueSNI,
+		URL:    masqueURL(),
+		Header: http.Header{http3.CapsuleProtocolHeader: []string{"?1"}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	rsp, err := str.ReadResponse()
+	if err != nil {
+		return 0, err
+	}
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return 0, fmt.Errorf("%w: status %d", errMasqueUnsupported, rsp.StatusCode)
+	}
+	return time.Since(start), nil
+}
+
+// masqueURL expands the default CONNECT-UDP template for masqueTarget.
+// Colons of IPv6 targets are percent-encoded as RFC 9298 requires.
+func masqueURL() *url.URL {
+	host := masqueTarget.Addr().String()
+	return &url.URL{
+		Scheme:  "https",
+		Host:    MasqueSNI,
+		Path:    fmt.Sprintf(masqueUDPPath, host, masqueTarget.Port()),
+		RawPath: fmt.Sprintf(masqueUDPPath, strings.ReplaceAll(host, ":", "%3A"), masqueTarget.Port()),
+	}
+}
@@ -0,0 +1,213 @@
+package task
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/time/rate"
+
+	"github.com/peanut996/CloudflareWarpSpeedTest/utils"
+)
+
+const testMasqueSNI = "masque.test"
+
+var (
+	masqueCertOnce  sync.Once
+	masqueCert      tls.Certificate
+	masqueCertRoots *x509.CertPool
+	masqueCertErr   error
+)
+
+// testMasqueCertificate returns a self-signed certificate for
+// testMasqueSNI, shared by all test servers, and a pool trusting it.
+func testMasqueCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	masqueCertOnce.Do(func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			masqueCertErr = err
+			return
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			DNSNames:     []string{testMasqueSNI},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(24 * time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			masqueCertErr = err
+			return
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			masqueCertErr = err
+			return
+		}
+		masqueCertRoots = x509.NewCertPool()
+		masqueCertRoots.AddCert(cert)
+		masqueCert = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	})
+	if masqueCertErr != nil {
+		t.Fatal(masqueCertErr)
+	}
+	return masqueCert, masqueCertRoots
+}
+
+// startMasqueServer runs an HTTP/3 server on loopback with a certificate
+// for testMasqueSNI, and points the MASQUE settings at it. Unless
+// connectUDP is false it accepts CONNECT-UDP requests for MasqueTarget.
+func startMasqueServer(t *testing.T, connectUDP bool) netip.AddrPort {
+	t.Helper()
+	cert, roots := testMasqueCertificate(t)
+
+	origSNI, origTarget, origRoots := MasqueSNI, MasqueTarget, masqueRootCAs
+	t.Cleanup(func() {
+		MasqueSNI, MasqueTarget, masqueRootCAs = origSNI, origTarget, origRoots
+	})
+	MasqueSNI, MasqueTarget, masqueRootCAs = testMasqueSNI, "[2606:4700:4700::1111]:53", roots
+	masqueTarget = netip.MustParseAddrPort(MasqueTarget)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !connectUDP || r.Method != http.MethodConnect || r.Proto != "connect-udp" ||
+			r.URL.RawPath != "/.well-known/masque/udp/2606%3A4700%3A4700%3A%3A1111/53/" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	server := &http3.Server{
+		Handler:         handler,
+		EnableDatagrams: true,
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+		}),
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(conn)
+	t.Cleanup(func() {
+		server.Close()
+		conn.Close()
+	})
+	return conn.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+func newTestMasqueProber(t *testing.T) *masqueProber {
+	t.Helper()
+	prober, err := newMasqueProber()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(prober.Close)
+	return prober
+}
+
+func TestMasqueProber_Handshake(t *testing.T) {
+	masque := startMasqueServer(t, true)
+	plain := startMasqueServer(t, false)
+	silent := startFakeResponder(t, func([]byte) []byte { return nil })
+	prober := newTestMasqueProber(t)
+	limiter := rate.NewLimiter(rate.Inf, 0)
+
+	tests := []struct {
+		name string
+		addr netip.AddrPort
+		want probeStatus
+	}{
+		{name: "connect-udp", addr: masque, want: probeOK},
+		{name: "plain http3", addr: plain, want: probeInvalid},
+		{name: "silent", addr: silent, want: probeLost},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := prober.handshake(context.Background(), tt.addr, limiter, 500*time.Millisecond)
+			if err != nil {
+				t.Fatalf("handshake() error = %v", err)
+			}
+			if got.status != tt.want {
+				t.Fatalf("handshake() status = %v, want %v", got.status, tt.want)
+			}
+			if tt.want == probeOK && (got.rtt <= 0 || got.connect <= 0) {
+				t.Errorf("handshake() rtt = %v, connect = %v, want both positive", got.rtt, got.connect)
+			}
+		})
+	}
+}
+
+func TestWarping_Run_Masque(t *testing.T) {
+	addr := startMasqueServer(t, true)
+	origMode, origText, origFile, origTimes := ProbeMode, IPText, IPFile, PingTimes
+	defer func() {
+		ProbeMode, IPText, IPFile, PingTimes = origMode, origText, origFile, origTimes
+	}()
+	ProbeMode, IPText, IPFile, PingTimes = ProbeModeMasque, addr.String(), "", 2
+	origPorts := ports
+	defer func() { ports = origPorts }()
+
+	results := NewWarping().Run(context.Background())
+	if len(results) != 1 {
+		t.Fatalf("Run() returned %d results, want 1", len(results))
+	}
+	got := results[0]
+	if got.Status != utils.StatusOK || got.Received != 2 || got.Protocol != ProbeModeMasque || got.ConnectDelay <= 0 {
+		t.Errorf("Run() = status %v, received %d, protocol %q, connect %v", got.Status, got.Received, got.Protocol, got.ConnectDelay)
+	}
+}
+
+func TestMasqueURL(t *testing.T) {
+	origSNI := MasqueSNI
+	defer func() {
+		MasqueSNI = origSNI
+		masqueTarget = netip.MustParseAddrPort(MasqueTarget)
+	}()
+	MasqueSNI = testMasqueSNI
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{target: "1.1.1.1:53", want: "https://masque.test/.well-known/masque/udp/1.1.1.1/53/"},
+		{target: "[2001:db8::1]:443", want: "https://masque.test/.well-known/masque/udp/2001%3Adb8%3A%3A1/443/"},
+	}
+
+	for _, tt := range tests {
+		masqueTarget = netip.MustParseAddrPort(tt.target)
+		if got := masqueURL().String(); got != tt.want {
+			t.Errorf("masqueURL() for %s = %s, want %s", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultCIDRs(t *testing.T) {
+	origMode := ProbeMode
+	defer func() { ProbeMode = origMode }()
+
+	ProbeMode = ProbeModeMasque
+	v4, v6 := defaultCIDRs()
+	if !strings.HasPrefix(v4[0], "162.159.19") || !strings.HasPrefix(v6[0], "2606:4700:10") {
+		t.Errorf("defaultCIDRs() in MASQUE mode = %v, %v", v4, v6)
+	}
+	ProbeMode = ProbeModeWireGuard
+	if v4, _ := defaultCIDRs(); v4[0] != commonIPv4CIDRs[0] {
+		t.Errorf("defaultCIDRs() in WireGuard mode = %v", v4)
+	}
+}
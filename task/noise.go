@@ -0,0 +1,195 @@
+package task
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tai64n"
+)
+
+var errInvalidSharedSecret = errors.New("invalid shared secret")
+
+// noiseIdentity is the precomputed, probe independent part of a Noise IK
+// handshake from one static key pair to one peer. It is safe for
+// concurrent use.
+type noiseIdentity struct {
+	localStatic    *ecdh.PrivateKey
+	localStaticPub device.NoisePublicKey
+	remoteStatic   device.NoisePublicKey
+	remoteKey      *ecdh.PublicKey
+	staticStatic   []byte
+	// initialHash is the protocol hash already mixed with remoteStatic
+	initialHash [blake2s.Size]byte
+	// checker verifies MAC1 of messages sent to us
+	checker device.CookieChecker
+	// generator computes MAC1 of messages sent to the peer
+	generator device.CookieGenerator
+}
+
+func newNoiseIdentity(pri device.NoisePrivateKey, pub device.NoisePublicKey) (*noiseIdentity, error) {
+	localStatic, err := ecdh.X25519().NewPrivateKey(pri[:])
+	if err != nil {
+		return nil, err
+	}
+	remoteKey, err := ecdh.X25519().NewPublicKey(pub[:])
+	if err != nil {
+		return nil, err
+	}
+	id := &noiseIdentity{
+		localStatic:  localStatic,
+		remoteStatic: pub,
+		remoteKey:    remoteKey,
+		initialHash:  device.InitialHash,
+	}
+	copy(id.localStaticPub[:], localStatic.PublicKey().Bytes())
+	ss, err := sharedSecret(localStatic, remoteKey)
+	if err != nil {
+		return nil, err
+	}
+	id.staticStatic = ss
+	mixHash(&id.initialHash, pub[:])
+	id.checker.Init(id.localStaticPub)
+	id.generator.Init(pub)
+	return id, nil
+}
+
+// noiseInitiator keeps the initiator side of a Noise IK handshake after the
+// initiation has been written, which is everything needed to authenticate
+// the responder's MessageResponse.
+type noiseInitiator struct {
+	*noiseIdentity
+	localEphemeral *ecdh.PrivateKey
+	hash           [blake2s.Size]byte
+	chainKey       [blake2s.Size]byte
+	sender         uint32
+}
+
+// newInitiation creates a MessageInitiation with a fresh sender index,
+// ephemeral key and timestamp, sealed by sealInitiation.
+func (id *noiseIdentity) newInitiation() ([]byte, *noiseInitiator, error) {
+	hs := &noiseInitiator{
+		noiseIdentity: id,
+		hash:          id.initialHash,
+		chainKey:      device.InitialChainKey,
+	}
+	var err error
+	hs.localEphemeral, err = ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	var sender [4]byte
+	if _, err := rand.Read(sender[:]); err != nil {
+		return nil, nil, err
+	}
+	hs.sender = binary.LittleEndian.Uint32(sender[:])
+
+	msg := MessageInitiation{
+		Type:   device.MessageInitiationType,
+		Sender: hs.sender,
+	}
+	copy(msg.Ephemeral[:], hs.localEphemeral.PublicKey().Bytes())
+
+	device.KDF1(&hs.chainKey, hs.chainKey[:], msg.Ephemeral[:])
+	mixHash(&hs.hash, msg.Ephemeral[:])
+
+	// encrypt static key
+	ss, err := sharedSecret(hs.localEphemeral, id.remoteKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	var key [chacha20poly1305.KeySize]byte
+	device.KDF2(&hs.chainKey, &key, hs.chainKey[:], ss)
+	aead, _ := chacha20poly1305.New(key[:])
+	aead.Seal(msg.Static[:0], device.ZeroNonce[:], id.localStaticPub[:], hs.hash[:])
+	mixHash(&hs.hash, msg.Static[:])
+
+	// encrypt timestamp
+	device.KDF2(&hs.chainKey, &key, hs.chainKey[:], id.staticStatic)
+	timestamp := tai64n.Now()
+	aead, _ = chacha20poly1305.New(key[:])
+	aead.Seal(msg.Timestamp[:0], device.ZeroNonce[:], timestamp[:], hs.hash[:])
+	mixHash(&hs.hash, msg.Timestamp[:])
+
+	var buf [device.MessageInitiationSize]byte
+	writer := bytes.NewBuffer(buf[:0])
+	if err := binary.Write(writer, binary.LittleEndian, msg); err != nil {
+		return nil, nil, err
+	}
+	packet := writer.Bytes()
+
+	sealInitiation(packet, &id.generator)
+	return packet, hs, nil
+}
+
+// checkMAC1 verifies the MAC1 of a message addressed to the initiator.
+// WARP computes MACs with the reserved bytes zeroed, so both forms are
+// accepted.
+func (hs *noiseInitiator) checkMAC1(msg []byte) bool {
+	if hs.checker.CheckMAC1(msg) {
+		return true
+	}
+	zeroed := make([]byte, len(msg))
+	copy(zeroed, msg)
+	zeroed[1], zeroed[2], zeroed[3] = 0, 0, 0
+	return hs.checker.CheckMAC1(zeroed)
+}
+
+// consumeResponse finishes the Noise IK handshake with resp and reports
+// whether the responder proved knowledge of the remote static key. The
+// initiator state is left untouched so the same initiation can be answered
+// more than once.
+func (hs *noiseInitiator) consumeResponse(resp *device.MessageResponse) bool {
+	hash := hs.hash
+	chainKey := hs.chainKey
+
+	mixHash(&hash, resp.Ephemeral[:])
+	device.KDF1(&chainKey, chainKey[:], resp.Ephemeral[:])
+
+	remoteEphemeral, err := ecdh.X25519().NewPublicKey(resp.Ephemeral[:])
+	if err != nil {
+		return false
+	}
+	ss, err := sharedSecret(hs.localEphemeral, remoteEphemeral)
+	if err != nil {
+		return false
+	}
+	device.KDF1(&chainKey, chainKey[:], ss)
+
+	ss, err = sharedSecret(hs.localStatic, remoteEphemeral)
+	if err != nil {
+		return false
+	}
+	device.KDF1(&chainKey, chainKey[:], ss)
+
+	// WARP does not use a preshared key
+	var psk device.NoisePresharedKey
+	var tau [blake2s.Size]byte
+	var key [chacha20poly1305.KeySize]byte
+	device.KDF3(&chainKey, &tau, &key, chainKey[:], psk[:])
+	mixHash(&hash, tau[:])
+
+	aead, _ := chacha20poly1305.New(key[:])
+	_, err = aead.Open(nil, device.ZeroNonce[:], resp.Empty[:], hash[:])
+	return err == nil
+}
+
+func mixHash(h *[blake2s.Size]byte, data []byte) {
+	hash, _ := blake2s.New256(nil)
+	hash.Write(h[:])
+	hash.Write(data)
+	hash.Sum(h[:0])
+}
+
+func sharedSecret(sk *ecdh.PrivateKey, pk *ecdh.PublicKey) ([]byte, error) {
+	ss, err := sk.ECDH(pk)
+	if err != nil {
+		return nil, errInvalidSharedSecret
+	}
+	return ss, nil
+}
@@ -0,0 +1,171 @@
+package task
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+func clampPrivateKey(sk *device.NoisePrivateKey) {
+	sk[0] &= 248
+	sk[31] = (sk[31] & 127) | 64
+}
+
+func publicKeyOf(sk device.NoisePrivateKey) (pk device.NoisePublicKey) {
+	curve25519.ScalarBaseMult((*[device.NoisePublicKeySize]byte)(&pk), (*[device.NoisePrivateKeySize]byte)(&sk))
+	return
+}
+
+func newTestPrivateKey(t *testing.T) device.NoisePrivateKey {
+	t.Helper()
+	var sk device.NoisePrivateKey
+	if _, err := rand.Read(sk[:]); err != nil {
+		t.Fatal(err)
+	}
+	clampPrivateKey(&sk)
+	return sk
+}
+
+// respondTo answers an initiation with a real wireguard-go device holding
+// responderKey, returning the marshalled MessageResponse.
+func respondTo(t *testing.T, responderKey device.NoisePrivateKey, initiatorPub device.NoisePublicKey, packet []byte) []byte {
+	t.Helper()
+	tun, _, err := netstack.CreateNetTUN([]netip.Addr{}, []netip.Addr{}, 1420)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := device.NewDevice(tun, conn.NewDefaultBind(), device.NewLogger(device.LogLevelSilent, ""))
+	t.Cleanup(dev.Close)
+	if err := dev.SetPrivateKey(responderKey); err != nil {
+		t.Fatal(err)
+	}
+	initiatorPeer, err := dev.NewPeer(initiatorPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initiatorPeer.Start()
+
+	clean := append([]byte(nil), packet...)
+	clean[1], clean[2], clean[3] = 0, 0, 0
+	var msg device.MessageInitiation
+	if err := binary.Read(bytes.NewReader(clean), binary.LittleEndian, &msg); err != nil {
+		t.Fatal(err)
+	}
+	peer := dev.ConsumeMessageInitiation(&msg)
+	if peer == nil {
+		t.Fatal("responder rejected the initiation")
+	}
+	resp, err := dev.CreateMessageResponse(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, resp); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.Bytes()
+	generator := device.CookieGenerator{}
+	generator.Init(initiatorPub)
+	generator.AddMacs(out)
+	return out
+}
+
+func newTestIdentity(t *testing.T, initiatorKey device.NoisePrivateKey, responderPub device.NoisePublicKey) *noiseIdentity {
+	t.Helper()
+	id, err := newNoiseIdentity(initiatorKey, responderPub)
+	if err != nil {
+		t.Fatalf("newNoiseIdentity() error = %v", err)
+	}
+	return id
+}
+
+func TestNoiseIdentity_NewInitiation(t *testing.T) {
+	id := newTestIdentity(t, newTestPrivateKey(t), publicKeyOf(newTestPrivateKey(t)))
+
+	first, firstState, err := id.newInitiation()
+	if err != nil {
+		t.Fatalf("newInitiation() error = %v", err)
+	}
+	second, secondState, err := id.newInitiation()
+	if err != nil {
+		t.Fatalf("newInitiation() error = %v", err)
+	}
+
+	for _, packet := range [][]byte{first, second} {
+		if len(packet) != device.MessageInitiationSize {
+			t.Fatalf("newInitiation() packet size = %v, want %v", len(packet), device.MessageInitiationSize)
+		}
+		if packet[1] != reserved[0] || packet[2] != reserved[1] || packet[3] != reserved[2] {
+			t.Errorf("newInitiation() reserved = %v, want %v", packet[1:4], reserved)
+		}
+	}
+	if firstState.sender == secondState.sender {
+		t.Error("newInitiation() reused the sender index")
+	}
+	if bytes.Equal(first[8:40], second[8:40]) {
+		t.Error("newInitiation() reused the ephemeral key")
+	}
+}
+
+func TestNoiseInitiator_ConsumeResponse(t *testing.T) {
+	initiatorKey := newTestPrivateKey(t)
+	responderKey := newTestPrivateKey(t)
+	id := newTestIdentity(t, initiatorKey, publicKeyOf(responderKey))
+
+	data, hs, err := id.newInitiation()
+	if err != nil {
+		t.Fatalf("newInitiation() error = %v", err)
+	}
+	probe := &probePacket{msg: data, data: data, initiator: hs}
+
+	resp := respondTo(t, responderKey, publicKeyOf(initiatorKey), data)
+
+	if !probe.verifyResponse(resp) {
+		t.Error("verifyResponse() rejected a genuine response")
+	}
+	// the initiation may be answered again, so verification must not consume state
+	if !probe.verifyResponse(resp) {
+		t.Error("verifyResponse() rejected a genuine response the second time")
+	}
+
+	tampered := append([]byte(nil), resp...)
+	tampered[20] ^= 0xff
+	if probe.verifyResponse(tampered) {
+		t.Error("verifyResponse() accepted a response with a modified ephemeral key")
+	}
+
+	otherData, otherState, err := id.newInitiation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := &probePacket{msg: otherData, data: otherData, initiator: otherState}
+	if other.verifyResponse(resp) {
+		t.Error("verifyResponse() accepted a response for another initiation")
+	}
+}
+
+func BenchmarkNoiseIdentity_NewInitiation(b *testing.B) {
+	var initiatorKey, responderKey device.NoisePrivateKey
+	rand.Read(initiatorKey[:])
+	rand.Read(responderKey[:])
+	clampPrivateKey(&initiatorKey)
+	clampPrivateKey(&responderKey)
+	id, err := newNoiseIdentity(initiatorKey, publicKeyOf(responderKey))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, _, err := id.newInitiation(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
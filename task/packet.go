@@ -0,0 +1,119 @@
+package task
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+// probePacket is the handshake initiation sent by one probe together with
+// what is needed to check the reply to it.
+type probePacket struct {
+	// msg is the WireGuard message and data the datagram carrying it, which
+	// differ when AmneziaWG padding is configured.
+	msg  []byte
+	data []byte
+	// initiator is nil for the built-in packet, whose private key is unknown
+	initiator *noiseInitiator
+}
+
+// newProbePacket returns the initiation for the next probe: a fresh one
+// when a private key is configured, otherwise the built-in packet.
+func newProbePacket() (*probePacket, error) {
+	if identity == nil {
+		return newBuiltinProbePacket()
+	}
+	msg, hs, err := identity.newInitiation()
+	if err != nil {
+		return nil, err
+	}
+	return &probePacket{msg: msg, data: padInitiation(msg), initiator: hs}, nil
+}
+
+// newBuiltinProbePacket returns the built-in packet with a fresh sender
+// index, so that a late reply to an earlier probe is not taken for the
+// reply to this one. The sender index is covered by the MACs but not by
+// the Noise hash, so resealing keeps the packet valid.
+func newBuiltinProbePacket() (*probePacket, error) {
+	msg := make([]byte, len(warpHandshakePacket))
+	copy(msg, warpHandshakePacket)
+	if _, err := rand.Read(msg[4:8]); err != nil {
+		return nil, err
+	}
+	generator := device.CookieGenerator{}
+	generator.Init(builtinPublicKey())
+	sealInitiation(msg, &generator)
+	return &probePacket{msg: msg, data: padInitiation(msg)}, nil
+}
+
+func (p *probePacket) sender() uint32 {
+	return binary.LittleEndian.Uint32(p.msg[4:8])
+}
+
+// remotePublicKey returns the peer key the initiation was built for.
+func (p *probePacket) remotePublicKey() device.NoisePublicKey {
+	if p.initiator != nil {
+		return p.initiator.remoteStatic
+	}
+	return builtinPublicKey()
+}
+
+// builtinPublicKey returns the peer key of the built-in packet.
+func builtinPublicKey() device.NoisePublicKey {
+	pub, _ := getNoisePublicKeyFromBase64(WarpPublicKey)
+	return pub
+}
+
+// verifyResponse reports whether buf is a WireGuard MessageResponse to the
+// initiation. Without a private key only the framing and receiver index
+// can be checked; with one, MAC1 and the Noise IK response are verified as
+// well.
+func (p *probePacket) verifyResponse(buf []byte) bool {
+	buf = stripResponsePadding(buf)
+	if len(buf) != wireguardHandshakeRespBytes || !hasHeader(buf, ResponsePacketMagicHeader) {
+		return false
+	}
+	var resp device.MessageResponse
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &resp); err != nil {
+		return false
+	}
+	if resp.Receiver != p.sender() {
+		return false
+	}
+	if p.initiator == nil {
+		return true
+	}
+	return p.initiator.checkMAC1(buf) && p.initiator.consumeResponse(&resp)
+}
+
+func isCookieReply(buf []byte) bool {
+	return len(buf) == device.MessageCookieReplySize && hasHeader(buf, UnderloadPacketMagicHeader)
+}
+
+// answerCookieReply decrypts the cookie an endpoint under load sent in reply
+// to the initiation and returns the datagram to resend, whose initiation
+// carries a valid MAC2.
+func (p *probePacket) answerCookieReply(buf []byte) ([]byte, bool) {
+	var reply device.MessageCookieReply
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &reply); err != nil {
+		return nil, false
+	}
+	if reply.Receiver != p.sender() {
+		return nil, false
+	}
+
+	packet := make([]byte, len(p.msg))
+	copy(packet, p.msg)
+
+	generator := device.CookieGenerator{}
+	generator.Init(p.remotePublicKey())
+	sealInitiation(packet, &generator)
+	if !generator.ConsumeReply(&reply) {
+		return nil, false
+	}
+	sealInitiation(packet, &generator)
+
+	return padInitiation(packet), true
+}
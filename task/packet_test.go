@@ -0,0 +1,141 @@
+package task
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+func TestNewProbePacket(t *testing.T) {
+	origIdentity := identity
+	defer func() { identity = origIdentity }()
+
+	identity = nil
+	probe, err := newProbePacket()
+	if err != nil {
+		t.Fatalf("newProbePacket() error = %v", err)
+	}
+	other, err := newProbePacket()
+	if err != nil {
+		t.Fatalf("newProbePacket() error = %v", err)
+	}
+	if probe.initiator != nil || !bytes.Equal(probe.msg[:4], warpHandshakePacket[:4]) ||
+		!bytes.Equal(probe.msg[8:116], warpHandshakePacket[8:116]) {
+		t.Error("newProbePacket() without a private key should replay the built-in initiation")
+	}
+	if probe.sender() == other.sender() {
+		t.Error("newProbePacket() should give every built-in probe a fresh sender index")
+	}
+	checker := device.CookieChecker{}
+	checker.Init(builtinPublicKey())
+	msg := append([]byte(nil), probe.msg...)
+	msg[1], msg[2], msg[3] = 0, 0, 0
+	if !checker.CheckMAC1(msg) {
+		t.Error("newProbePacket() built-in probe has an invalid MAC1")
+	}
+
+	identity = newTestIdentity(t, newTestPrivateKey(t), publicKeyOf(newTestPrivateKey(t)))
+	first, err := newProbePacket()
+	if err != nil {
+		t.Fatalf("newProbePacket() error = %v", err)
+	}
+	second, err := newProbePacket()
+	if err != nil {
+		t.Fatalf("newProbePacket() error = %v", err)
+	}
+	if first.sender() == second.sender() || bytes.Equal(first.data, second.data) {
+		t.Error("newProbePacket() should build a fresh initiation for every probe")
+	}
+}
+
+func TestProbePacket_VerifyResponse_BuiltIn(t *testing.T) {
+	probe := &probePacket{msg: warpHandshakePacket, data: warpHandshakePacket}
+
+	valid := make([]byte, device.MessageResponseSize)
+	valid[0] = device.MessageResponseType
+	copy(valid[8:12], warpHandshakePacket[4:8])
+
+	wrongReceiver := append([]byte(nil), valid...)
+	wrongReceiver[8] ^= 0xff
+
+	wrongType := append([]byte(nil), valid...)
+	wrongType[0] = device.MessageCookieReplyType
+
+	tests := []struct {
+		name string
+		buf  []byte
+		want bool
+	}{
+		{name: "matching receiver", buf: valid, want: true},
+		{name: "wrong receiver", buf: wrongReceiver, want: false},
+		{name: "wrong type", buf: wrongType, want: false},
+		{name: "wrong size", buf: valid[:64], want: false},
+		{name: "garbage", buf: bytes.Repeat([]byte{0xaa}, device.MessageResponseSize), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := probe.verifyResponse(tt.buf); got != tt.want {
+				t.Errorf("verifyResponse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbePacket_AnswerCookieReply(t *testing.T) {
+	responderKey := newTestPrivateKey(t)
+	responderPub := publicKeyOf(responderKey)
+	id := newTestIdentity(t, newTestPrivateKey(t), responderPub)
+
+	data, hs, err := id.newInitiation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe := &probePacket{msg: data, data: data, initiator: hs}
+
+	checker := device.CookieChecker{}
+	checker.Init(responderPub)
+	src := []byte{127, 0, 0, 1, 0x30, 0x39}
+	clean := append([]byte(nil), data...)
+	clean[1], clean[2], clean[3] = 0, 0, 0
+	reply, err := checker.CreateReply(clean, probe.sender(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, reply); err != nil {
+		t.Fatal(err)
+	}
+	if !isCookieReply(buf.Bytes()) {
+		t.Fatal("isCookieReply() = false for a cookie reply")
+	}
+
+	answered, ok := probe.answerCookieReply(buf.Bytes())
+	if !ok {
+		t.Fatal("answerCookieReply() rejected a valid cookie reply")
+	}
+	if !bytes.Equal(answered[1:4], data[1:4]) {
+		t.Errorf("answerCookieReply() reserved = %v, want %v", answered[1:4], data[1:4])
+	}
+	answered[1], answered[2], answered[3] = 0, 0, 0
+	if !checker.CheckMAC1(answered) {
+		t.Error("answerCookieReply() produced an invalid MAC1")
+	}
+	if !checker.CheckMAC2(answered, src) {
+		t.Error("answerCookieReply() produced an invalid MAC2")
+	}
+
+	wrongReceiver := append([]byte(nil), buf.Bytes()...)
+	wrongReceiver[4] ^= 0xff
+	if _, ok := probe.answerCookieReply(wrongReceiver); ok {
+		t.Error("answerCookieReply() accepted a reply for another sender index")
+	}
+
+	tampered := append([]byte(nil), buf.Bytes()...)
+	tampered[40] ^= 0xff
+	if _, ok := probe.answerCookieReply(tampered); ok {
+		t.Error("answerCookieReply() accepted a reply with a corrupted cookie")
+	}
+}
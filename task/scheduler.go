@@ -0,0 +1,90 @@
+package task
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	defaultMaxProbeTimeout = time.Second
+	// minProbeTimeout keeps very stable paths from timing out on the first
+	// late reply, as TCP's minimum RTO does.
+	minProbeTimeout = 200 * time.Millisecond
+	// rtoClockGranularity is the G of RFC 6298.
+	rtoClockGranularity = time.Millisecond
+)
+
+var (
+	// ProbeInterval is the pause between two probes to the same endpoint.
+	ProbeInterval time.Duration
+
+	// ProbeJitter adds a random pause of up to this duration to every
+	// interval, so probes do not line up with periodic cross traffic.
+	ProbeJitter time.Duration
+
+	// MaxProbeTimeout bounds how long a probe waits for its reply. It is
+	// used until the first reply, after which the timeout follows the RTT.
+	MaxProbeTimeout = defaultMaxProbeTimeout
+)
+
+// probeScheduler paces the probes to one endpoint and derives their
+// timeouts from the RTTs seen so far, like TCP's retransmission timer.
+type probeScheduler struct {
+	srtt    time.Duration
+	rttvar  time.Duration
+	sampled bool
+	started bool
+}
+
+// timeout returns SRTT + 4·RTTVAR once a reply has been seen, clamped to
+// [minProbeTimeout, MaxProbeTimeout], and MaxProbeTimeout before that.
+func (s *probeScheduler) timeout() time.Duration {
+	if !s.sampled {
+		return MaxProbeTimeout
+	}
+	rto := s.srtt + max(rtoClockGranularity, 4*s.rttvar)
+	return min(max(rto, minProbeTimeout), MaxProbeTimeout)
+}
+
+// observe updates the estimator with an RTT sample as in RFC 6298.
+func (s *probeScheduler) observe(rtt time.Duration) {
+	if !s.sampled {
+		s.srtt, s.rttvar, s.sampled = rtt, rtt/2, true
+		return
+	}
+	diff := s.srtt - rtt
+	if diff < 0 {
+		diff = -diff
+	}
+	s.rttvar = (3*s.rttvar + diff) / 4
+	s.srtt = (7*s.srtt + rtt) / 8
+}
+
+// wait sleeps for the probe interval plus jitter before every probe but the
+// first. It fails if ctx is done, even when there is no pause, so that an
+// interrupted scan stops probing endpoints in progress.
+func (s *probeScheduler) wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !s.started {
+		s.started = true
+		return nil
+	}
+	pause := ProbeInterval
+	if ProbeJitter > 0 {
+		pause += rand.N(ProbeJitter + 1)
+	}
+	if pause <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(pause)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,85 @@
+package task
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestProbeScheduler_Timeout(t *testing.T) {
+	orig := MaxProbeTimeout
+	defer func() { MaxProbeTimeout = orig }()
+	MaxProbeTimeout = time.Second
+
+	tests := []struct {
+		name    string
+		samples []time.Duration
+		want    time.Duration
+	}{
+		{name: "no reply yet", want: time.Second},
+		// SRTT 100ms, RTTVAR 50ms
+		{name: "first sample", samples: []time.Duration{100 * time.Millisecond}, want: 300 * time.Millisecond},
+		// RTTVAR 3/4·50 + 1/4·100 = 62.5ms, SRTT 7/8·100 + 1/8·200 = 112.5ms
+		{name: "second sample", samples: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}, want: 362500 * time.Microsecond},
+		{name: "stable path", samples: []time.Duration{10 * time.Millisecond, 10 * time.Millisecond, 10 * time.Millisecond}, want: minProbeTimeout},
+		{name: "slow path", samples: []time.Duration{600 * time.Millisecond}, want: time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s probeScheduler
+			for _, rtt := range tt.samples {
+				s.observe(rtt)
+			}
+			if got := s.timeout(); got != tt.want {
+				t.Errorf("probeScheduler.timeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeScheduler_Wait(t *testing.T) {
+	origInterval, origJitter := ProbeInterval, ProbeJitter
+	defer func() { ProbeInterval, ProbeJitter = origInterval, origJitter }()
+	ProbeInterval, ProbeJitter = 30*time.Millisecond, 20*time.Millisecond
+
+	var s probeScheduler
+	ctx := context.Background()
+	start := time.Now()
+	if err := s.wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Errorf("first wait() took %v, want no pause", elapsed)
+	}
+
+	start = time.Now()
+	if err := s.wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("second wait() took %v, want at least the interval", elapsed)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := s.wait(cancelled); err == nil {
+		t.Error("wait() should fail once ctx is done")
+	}
+}
+
+func TestProbeScheduler_Wait_NoPause(t *testing.T) {
+	origInterval, origJitter := ProbeInterval, ProbeJitter
+	defer func() { ProbeInterval, ProbeJitter = origInterval, origJitter }()
+	ProbeInterval, ProbeJitter = 0, 0
+
+	var s probeScheduler
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := s.wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := s.wait(ctx); err == nil {
+		t.Error("wait() without a pause should fail once ctx is done")
+	}
+}
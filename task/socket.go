@@ -0,0 +1,301 @@
+package task
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// socketPoolSize is the number of UDP sockets shared by all probes.
+	socketPoolSize = 4
+	// socketReadBuffer is the receive buffer asked for on pooled sockets,
+	// as bursts of replies to many concurrent probes overflow the default
+	// one and would show up as packet loss. The kernel may grant less.
+	socketReadBuffer = 4 << 20
+	// maxFinishedProbes bounds how many finished probes per endpoint are
+	// remembered to recognize their late replies.
+	maxFinishedProbes = 16
+)
+
+var (
+	errProbeTimeout  = errors.New("probe timed out")
+	errProbeInFlight = errors.New("a probe with the same sender index is in flight")
+)
+
+// socketPool sends every probe through a few unconnected UDP sockets. One
+// reader per socket hands replies to the waiting probe, matched by source
+// address and receiver index, so the number of file descriptors does not
+// grow with the number of endpoints.
+type socketPool struct {
+	socks []*poolSocket
+	next  atomic.Uint32
+	wg    sync.WaitGroup
+
+	m       sync.Mutex
+	pending map[netip.AddrPort][]*pendingProbe
+	// finished holds the sender indices of the last probes to an endpoint
+	// that are no longer waiting, until its probeConn is closed.
+	finished map[netip.AddrPort][]uint32
+}
+
+// pendingProbe is a probe waiting for its reply.
+type pendingProbe struct {
+	sender  uint32
+	replies chan probeReply
+}
+
+type probeReply struct {
+	data []byte
+	// at is when the reader received the datagram
+	at time.Time
+}
+
+// poolSocket is a pooled socket, relaying through a SOCKS5 association
+// when a proxy is configured.
+type poolSocket struct {
+	*net.UDPConn
+	// assoc is nil when probes go out directly
+	assoc *socksAssociation
+}
+
+func newPoolSocket() (*poolSocket, error) {
+	conn, err := listenProbeSocket()
+	if err != nil {
+		return nil, err
+	}
+	sock := &poolSocket{UDPConn: conn}
+	if proxy != nil {
+		// every socket gets its own association, as relays only accept
+		// datagrams from the first client port they see
+		if sock.assoc, err = proxy.associate(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return sock, nil
+}
+
+func (s *poolSocket) Close() error {
+	if s.assoc != nil {
+		s.assoc.Close()
+	}
+	return s.UDPConn.Close()
+}
+
+func (s *poolSocket) writeTo(b []byte, addr netip.AddrPort) (int, error) {
+	if s.assoc == nil {
+		return s.WriteToUDPAddrPort(b, addr)
+	}
+	if _, err := s.WriteToUDPAddrPort(encapsulate(b, addr), s.assoc.relay); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// readFrom reads the next datagram, unwrapping it when it comes from the
+// SOCKS5 relay. ok is false for datagrams to be ignored.
+func (s *poolSocket) readFrom(buf []byte) (data []byte, from netip.AddrPort, ok bool, err error) {
+	n, from, err := s.ReadFromUDPAddrPort(buf)
+	if err != nil {
+		return nil, from, false, err
+	}
+	from = unmapAddrPort(from)
+	if s.assoc == nil {
+		return buf[:n], from, true, nil
+	}
+	if from != s.assoc.relay {
+		return nil, from, false, nil
+	}
+	from, data, ok = decapsulate(buf[:n])
+	return data, from, ok, nil
+}
+
+func newSocketPool(size int) (*socketPool, error) {
+	p := &socketPool{
+		pending:  make(map[netip.AddrPort][]*pendingProbe),
+		finished: make(map[netip.AddrPort][]uint32),
+	}
+	for i := 0; i < size; i++ {
+		sock, err := newPoolSocket()
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		sock.SetReadBuffer(socketReadBuffer)
+		p.socks = append(p.socks, sock)
+		p.wg.Add(1)
+		go p.read(sock)
+	}
+	return p, nil
+}
+
+func (p *socketPool) Close() {
+	for _, sock := range p.socks {
+		sock.Close()
+	}
+	p.wg.Wait()
+}
+
+// conn returns the path to addr through one of the pooled sockets.
+func (p *socketPool) conn(addr netip.AddrPort) *probeConn {
+	sock := p.socks[int(p.next.Add(1))%len(p.socks)]
+	return &probeConn{pool: p, sock: sock, addr: unmapAddrPort(addr)}
+}
+
+func (p *socketPool) read(sock *poolSocket) {
+	defer p.wg.Done()
+	buf := make([]byte, 2048)
+	for {
+		payload, from, ok, err := sock.readFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if !ok {
+			continue
+		}
+		at := time.Now()
+		data := make([]byte, len(payload))
+		copy(data, payload)
+		p.deliver(from, probeReply{data: data, at: at})
+	}
+}
+
+// deliver hands reply to the probe it answers. Late replies to finished
+// probes are dropped. Any other reply, whose receiver index cannot be read
+// or matches no probe, goes to the first probe waiting on the source
+// address, so that the probe can reject it.
+func (p *socketPool) deliver(from netip.AddrPort, reply probeReply) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	probes := p.pending[from]
+	if len(probes) == 0 {
+		return
+	}
+	target := probes[0]
+	if receiver, ok := replyReceiver(reply.data); ok {
+		if slices.Contains(p.finished[from], receiver) {
+			return
+		}
+		for _, probe := range probes {
+			if probe.sender == receiver {
+				target = probe
+				break
+			}
+		}
+	}
+	select {
+	case target.replies <- reply:
+	default:
+	}
+}
+
+func (p *socketPool) register(addr netip.AddrPort, sender uint32) (*pendingProbe, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	for _, probe := range p.pending[addr] {
+		if probe.sender == sender {
+			return nil, errProbeInFlight
+		}
+	}
+	// a cookie retry waits on the sender index again
+	p.finished[addr] = slices.DeleteFunc(p.finished[addr], func(s uint32) bool { return s == sender })
+	probe := &pendingProbe{sender: sender, replies: make(chan probeReply, 1)}
+	p.pending[addr] = append(p.pending[addr], probe)
+	return probe, nil
+}
+
+func (p *socketPool) unregister(addr netip.AddrPort, probe *pendingProbe) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	probes := p.pending[addr]
+	for i, pending := range probes {
+		if pending == probe {
+			probes = append(probes[:i], probes[i+1:]...)
+			break
+		}
+	}
+	if len(probes) == 0 {
+		delete(p.pending, addr)
+	} else {
+		p.pending[addr] = probes
+	}
+	finished := append(p.finished[addr], probe.sender)
+	if len(finished) > maxFinishedProbes {
+		finished = finished[1:]
+	}
+	p.finished[addr] = finished
+}
+
+// forget drops what is remembered about the finished probes to addr.
+func (p *socketPool) forget(addr netip.AddrPort) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	delete(p.finished, addr)
+}
+
+// probeConn sends to one endpoint through a pooled socket.
+type probeConn struct {
+	pool *socketPool
+	sock *poolSocket
+	addr netip.AddrPort
+}
+
+func (c *probeConn) Write(b []byte) (int, error) {
+	return c.sock.writeTo(b, c.addr)
+}
+
+// Close ends the probes to the endpoint. Replies arriving afterwards are
+// dropped as no probe waits for them.
+func (c *probeConn) Close() error {
+	c.pool.forget(c.addr)
+	return nil
+}
+
+// exchange writes packet and waits up to timeout for the reply to the
+// initiation with the given sender index.
+func (c *probeConn) exchange(packet []byte, sender uint32, timeout time.Duration) ([]byte, time.Duration, error) {
+	probe, err := c.pool.register(c.addr, sender)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer c.pool.unregister(c.addr, probe)
+
+	startTime := time.Now()
+	if _, err := c.Write(packet); err != nil {
+		return nil, 0, err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case reply := <-probe.replies:
+		return reply.data, reply.at.Sub(startTime), nil
+	case <-timer.C:
+		return nil, 0, errProbeTimeout
+	}
+}
+
+// replyReceiver returns the receiver index of a handshake response or
+// cookie reply, which is the sender index of the initiation it answers.
+func replyReceiver(buf []byte) (uint32, bool) {
+	if isCookieReply(buf) {
+		return binary.LittleEndian.Uint32(buf[4:8]), true
+	}
+	msg := stripResponsePadding(buf)
+	if len(msg) == wireguardHandshakeRespBytes && hasHeader(msg, ResponsePacketMagicHeader) {
+		return binary.LittleEndian.Uint32(msg[8:12]), true
+	}
+	return 0, false
+}
+
+func unmapAddrPort(addr netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+}
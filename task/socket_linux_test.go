@@ -0,0 +1,39 @@
+package task
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestSocketPool_ReadBuffer(t *testing.T) {
+	data, err := os.ReadFile("/proc/sys/net/core/rmem_max")
+	if err != nil {
+		t.Skip(err)
+	}
+	rmemMax, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := newTestSocketPool(t)
+
+	for _, sock := range pool.socks {
+		raw, err := sock.SyscallConn()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var size int
+		raw.Control(func(fd uintptr) {
+			size, err = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the kernel doubles the size asked for, capped at rmem_max
+		if want := min(socketReadBuffer, rmemMax); size < want {
+			t.Errorf("receive buffer = %d bytes, want at least %d", size, want)
+		}
+	}
+}
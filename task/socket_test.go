@@ -0,0 +1,197 @@
+package task
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+// startFakeResponder answers every datagram received on loopback with
+// reply(datagram), unless it returns nil.
+func startFakeResponder(t *testing.T, reply func([]byte) []byte) netip.AddrPort {
+	t.Helper()
+	sock, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sock.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := sock.ReadFromUDPAddrPort(buf)
+			if err != nil {
+				return
+			}
+			if out := reply(buf[:n]); out != nil {
+				sock.WriteToUDPAddrPort(out, from)
+			}
+		}
+	}()
+	return sock.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+// responseTo frames a MessageResponse to the initiation msg, which is all
+// the built-in packet can check.
+func responseTo(msg []byte) []byte {
+	resp := make([]byte, device.MessageResponseSize)
+	resp[0] = device.MessageResponseType
+	copy(resp[8:12], msg[4:8])
+	return resp
+}
+
+func newTestSocketPool(t *testing.T) *socketPool {
+	t.Helper()
+	pool, err := newSocketPool(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestHandshake_SocketPool(t *testing.T) {
+	origIdentity := identity
+	defer func() { identity = origIdentity }()
+	identity = nil
+
+	tests := []struct {
+		name  string
+		reply func([]byte) []byte
+		want  probeStatus
+	}{
+		{name: "valid response", reply: responseTo, want: probeOK},
+		{name: "garbage reply", reply: func([]byte) []byte { return []byte("hello") }, want: probeInvalid},
+		{
+			name: "response to another probe",
+			reply: func(msg []byte) []byte {
+				resp := responseTo(msg)
+				resp[8]++
+				return resp
+			},
+			want: probeInvalid,
+		},
+	}
+
+	pool := newTestSocketPool(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := startFakeResponder(t, tt.reply)
+			got, err := handshake(context.Background(), pool.conn(addr), nil, 200*time.Millisecond)
+			if err != nil {
+				t.Fatalf("handshake() error = %v", err)
+			}
+			if got.status != tt.want {
+				t.Errorf("handshake() status = %v, want %v", got.status, tt.want)
+			}
+			if got.status == probeOK && got.rtt <= 0 {
+				t.Errorf("handshake() rtt = %v, want > 0", got.rtt)
+			}
+		})
+	}
+}
+
+func TestSocketPool_Demultiplex(t *testing.T) {
+	addr := startFakeResponder(t, responseTo)
+	pool := newTestSocketPool(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(sender uint32) {
+			defer wg.Done()
+			packet := make([]byte, device.MessageInitiationSize)
+			packet[0] = device.MessageInitiationType
+			binary.LittleEndian.PutUint32(packet[4:8], sender)
+			reply, _, err := pool.conn(addr).exchange(packet, sender, time.Second)
+			if err != nil {
+				t.Errorf("exchange() for sender %d error = %v", sender, err)
+				return
+			}
+			if got, _ := replyReceiver(reply); got != sender {
+				t.Errorf("exchange() for sender %d got the reply for %d", sender, got)
+			}
+		}(uint32(i))
+	}
+	wg.Wait()
+}
+
+func TestSocketPool_Register(t *testing.T) {
+	pool := newTestSocketPool(t)
+	addr := netip.MustParseAddrPort("162.159.192.1:2408")
+
+	probe, err := pool.register(addr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.register(addr, 1); err != errProbeInFlight {
+		t.Errorf("register() of a duplicate sender error = %v, want %v", err, errProbeInFlight)
+	}
+	pool.unregister(addr, probe)
+	if _, err := pool.register(addr, 1); err != nil {
+		t.Errorf("register() after unregister error = %v", err)
+	}
+}
+
+func TestSocketPool_LateReply(t *testing.T) {
+	pool := newTestSocketPool(t)
+	addr := netip.MustParseAddrPort("162.159.192.1:2408")
+	reply := func(receiver uint32) probeReply {
+		resp := make([]byte, device.MessageResponseSize)
+		resp[0] = device.MessageResponseType
+		binary.LittleEndian.PutUint32(resp[8:12], receiver)
+		return probeReply{data: resp}
+	}
+
+	first, _ := pool.register(addr, 1)
+	pool.unregister(addr, first)
+	second, _ := pool.register(addr, 2)
+	pool.deliver(addr, reply(1))
+	select {
+	case <-second.replies:
+		t.Error("deliver() handed the late reply to a finished probe to the next probe")
+	default:
+	}
+	pool.deliver(addr, reply(3))
+	select {
+	case <-second.replies:
+	default:
+		t.Error("deliver() dropped a reply matching no probe instead of handing it over for rejection")
+	}
+}
+
+func TestReplyReceiver(t *testing.T) {
+	response := make([]byte, device.MessageResponseSize)
+	response[0] = device.MessageResponseType
+	binary.LittleEndian.PutUint32(response[8:12], 7)
+
+	cookie := make([]byte, device.MessageCookieReplySize)
+	cookie[0] = device.MessageCookieReplyType
+	binary.LittleEndian.PutUint32(cookie[4:8], 9)
+
+	tests := []struct {
+		name   string
+		buf    []byte
+		want   uint32
+		wantOK bool
+	}{
+		{name: "response", buf: response, want: 7, wantOK: true},
+		{name: "cookie reply", buf: cookie, want: 9, wantOK: true},
+		{name: "truncated response", buf: response[:40]},
+		{name: "garbage", buf: []byte("hello")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := replyReceiver(tt.buf)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("replyReceiver() = (%v, %v), want (%v, %v)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
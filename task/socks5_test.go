@@ -0,0 +1,215 @@
+package task
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+// startSocksServer runs a SOCKS5 server on loopback that only supports UDP
+// ASSOCIATE. It asks for a username and password when user is not empty.
+func startSocksServer(t *testing.T, user, password string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			control, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSocks(control, user, password)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func serveSocks(control net.Conn, user, password string) {
+	defer control.Close()
+	var head [2]byte
+	if _, err := io.ReadFull(control, head[:]); err != nil {
+		return
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(control, methods); err != nil {
+		return
+	}
+	method := byte(socksMethodNone)
+	if user != "" {
+		method = socksMethodPassword
+	}
+	if !bytes.Contains(methods, []byte{method}) {
+		control.Write([]byte{socksVersion, socksNoMethod})
+		return
+	}
+	control.Write([]byte{socksVersion, method})
+	if user != "" {
+		var ver, n [1]byte
+		io.ReadFull(control, ver[:])
+		io.ReadFull(control, n[:])
+		gotUser := make([]byte, n[0])
+		io.ReadFull(control, gotUser)
+		io.ReadFull(control, n[:])
+		gotPassword := make([]byte, n[0])
+		if _, err := io.ReadFull(control, gotPassword); err != nil {
+			return
+		}
+		if string(gotUser) != user || string(gotPassword) != password {
+			control.Write([]byte{socksAuthVersion, 1})
+			return
+		}
+		control.Write([]byte{socksAuthVersion, 0})
+	}
+
+	var request [3]byte
+	if _, err := io.ReadFull(control, request[:]); err != nil {
+		return
+	}
+	if _, err := readSocksAddr(control); err != nil {
+		return
+	}
+	if request[1] != socksCmdAssociate {
+		control.Write([]byte{socksVersion, 7, 0, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return
+	}
+	defer relay.Close()
+	// an unspecified bind address tells the client to use the proxy's
+	reply := []byte{socksVersion, 0, 0, socksAtypIPv4, 0, 0, 0, 0}
+	reply = binary.BigEndian.AppendUint16(reply, uint16(relay.LocalAddr().(*net.UDPAddr).Port))
+	control.Write(reply)
+
+	go func() {
+		var client netip.AddrPort
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := relay.ReadFromUDPAddrPort(buf)
+			if err != nil {
+				return
+			}
+			if !client.IsValid() || from == client {
+				client = from
+				if dst, payload, ok := decapsulate(buf[:n]); ok {
+					relay.WriteToUDPAddrPort(payload, dst)
+				}
+				continue
+			}
+			relay.WriteToUDPAddrPort(encapsulate(buf[:n], unmapAddrPort(from)), client)
+		}
+	}()
+	// the association lasts as long as the control connection
+	io.Copy(io.Discard, control)
+}
+
+func setSocksProxy(t *testing.T, s string) {
+	t.Helper()
+	orig := SocksProxy
+	t.Cleanup(func() {
+		SocksProxy = orig
+		loadSocksProxy()
+	})
+	SocksProxy = s
+	loadSocksProxy()
+}
+
+func TestSocketPool_SocksProxy(t *testing.T) {
+	setSocksProxy(t, "socks5://user:secret@"+startSocksServer(t, "user", "secret"))
+	addr := startFakeResponder(t, responseTo)
+	pool := newTestSocketPool(t)
+
+	for sender := uint32(1); sender <= 4; sender++ {
+		packet := make([]byte, device.MessageInitiationSize)
+		packet[0] = device.MessageInitiationType
+		binary.LittleEndian.PutUint32(packet[4:8], sender)
+		reply, rtt, err := pool.conn(addr).exchange(packet, sender, time.Second)
+		if err != nil {
+			t.Fatalf("exchange() error = %v", err)
+		}
+		if got, _ := replyReceiver(reply); got != sender || rtt <= 0 {
+			t.Errorf("exchange() = receiver %d, rtt %v, want %d and a positive rtt", got, rtt, sender)
+		}
+	}
+	if got := probeProxy(); got != "socks5://"+proxy.addr {
+		t.Errorf("probeProxy() = %q", got)
+	}
+}
+
+func TestSocketPool_SocksProxyAuth(t *testing.T) {
+	addr := startSocksServer(t, "user", "secret")
+	tests := []struct {
+		name     string
+		proxy    string
+		wantAuth bool
+	}{
+		{name: "wrong password", proxy: "socks5://user:wrong@" + addr, wantAuth: true},
+		{name: "no credentials", proxy: addr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setSocksProxy(t, tt.proxy)
+			pool, err := newSocketPool(1)
+			if err == nil {
+				pool.Close()
+				t.Fatal("newSocketPool() should fail")
+			}
+			if tt.wantAuth && !errors.Is(err, errSocksAuth) {
+				t.Errorf("newSocketPool() error = %v, want %v", err, errSocksAuth)
+			}
+		})
+	}
+}
+
+func TestParseSocksProxy(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    socksProxy
+		wantErr bool
+	}{
+		{name: "host and port", in: "127.0.0.1:1080", want: socksProxy{addr: "127.0.0.1:1080"}},
+		{name: "url", in: "socks5://proxy.example:1080", want: socksProxy{addr: "proxy.example:1080"}},
+		{name: "credentials", in: "socks5://user:p%40ss@[::1]:1080", want: socksProxy{addr: "[::1]:1080", user: "user", password: "p@ss"}},
+		{name: "missing port", in: "127.0.0.1", wantErr: true},
+		{name: "other scheme", in: "http://127.0.0.1:8080", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSocksProxy(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSocksProxy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && *got != tt.want {
+				t.Errorf("parseSocksProxy() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncapsulate(t *testing.T) {
+	payload := []byte("handshake")
+	for _, dst := range []string{"162.159.192.1:2408", "[2606:4700:d0::1]:500"} {
+		addr := netip.MustParseAddrPort(dst)
+		from, got, ok := decapsulate(encapsulate(payload, addr))
+		if !ok || from != addr || !bytes.Equal(got, payload) {
+			t.Errorf("decapsulate(encapsulate(%v)) = %v, %q, %v", addr, from, got, ok)
+		}
+	}
+	if _, _, ok := decapsulate([]byte{0, 0, 1, socksAtypIPv4, 1, 2, 3, 4, 0, 1}); ok {
+		t.Error("decapsulate() should reject fragments")
+	}
+}
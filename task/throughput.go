@@ -0,0 +1,190 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/peanut996/CloudflareWarpSpeedTest/i18n"
+	"github.com/peanut996/CloudflareWarpSpeedTest/utils"
+)
+
+const (
+	defaultSpeedTestDuration = 10 * time.Second
+	tunnelDialTimeout        = 5 * time.Second
+	uploadChunkSize          = 32 * 1024
+)
+
+var (
+	// SpeedTestCount is how many of the best endpoints get a throughput
+	// test; 0 disables the phase.
+	SpeedTestCount = 0
+
+	SpeedTestDuration = defaultSpeedTestDuration
+
+	DownloadURL = "https://speed.cloudflare.com/__down?bytes=1000000000"
+
+	UploadURL = "https://speed.cloudflare.com/__up"
+)
+
+// TestThroughput measures download and upload bandwidth through a real
+// WireGuard tunnel for the first SpeedTestCount valid endpoints in ipSet.
+// Endpoints not measured before ctx is done keep zero speeds.
+func TestThroughput(ctx context.Context, ipSet utils.PingDelaySet) utils.PingDelaySet {
+	if SpeedTestCount <= 0 || len(ipSet) == 0 {
+		return ipSet
+	}
+	if PrivateKey == "" {
+		log.Fatalln(i18n.QueryI18n(i18n.ThroughputPrivateKeyRequired))
+	}
+	if SpeedTestDuration <= 0 {
+		SpeedTestDuration = defaultSpeedTestDuration
+	}
+
+	targets := tunnelTargets(ipSet, SpeedTestCount)
+
+	bar := utils.NewBar(len(targets), i18n.QueryI18n(i18n.ThroughputTesting), "")
+	tested := 0
+	for _, i := range targets {
+		if ctx.Err() != nil {
+			break
+		}
+		down, up, err := measureThroughput(ctx, ipSet[i].IP)
+		if err == nil {
+			ipSet[i].DownloadSpeed = down
+			ipSet[i].UploadSpeed = up
+			tested++
+		}
+		bar.Grow(1, strconv.Itoa(tested))
+	}
+	bar.Done()
+	return ipSet
+}
+
+// measureThroughput returns download and upload speed in Mbps through a
+// tunnel to endpoint.
+func measureThroughput(ctx context.Context, endpoint *net.UDPAddr) (down, up float64, err error) {
+	tun, err := newTunnel(endpoint)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tun.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				ctx, cancel := context.WithTimeout(ctx, tunnelDialTimeout)
+				defer cancel()
+				return tun.DialContext(ctx, network, addr)
+			},
+			ResponseHeaderTimeout: tunnelDialTimeout,
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	if DownloadURL != "" {
+		down, err = measureDownload(ctx, client)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if UploadURL != "" {
+		up, err = measureUpload(ctx, client)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return down, up, nil
+}
+
+// measureDownload and measureUpload stop after SpeedTestDuration; they fail
+// if parent is done first.
+func measureDownload(parent context.Context, client *http.Client) (float64, error) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, DownloadURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.New(resp.Status)
+	}
+
+	start := time.Now()
+	timer := time.AfterFunc(SpeedTestDuration, cancel)
+	defer timer.Stop()
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err := parent.Err(); err != nil {
+		return 0, err
+	}
+	if err != nil && ctx.Err() == nil {
+		return 0, err
+	}
+	return mbps(n, time.Since(start)), nil
+}
+
+func measureUpload(parent context.Context, client *http.Client) (float64, error) {
+	ctx, cancel := context.WithTimeout(parent, SpeedTestDuration)
+	defer cancel()
+	body := &zeroReader{ctx: ctx}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, UploadURL, body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	elapsed := time.Since(start)
+	if err := parent.Err(); err != nil {
+		return 0, err
+	}
+	if err != nil && ctx.Err() == nil {
+		return 0, err
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+	sent := body.n.Load()
+	if sent == 0 {
+		return 0, errors.New("no data uploaded")
+	}
+	return mbps(sent, elapsed), nil
+}
+
+// zeroReader yields zeros until its context is done, counting the bytes
+// handed to the HTTP client.
+type zeroReader struct {
+	ctx context.Context
+	n   atomic.Int64
+}
+
+func (r *zeroReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, io.EOF
+	}
+	if len(p) > uploadChunkSize {
+		p = p[:uploadChunkSize]
+	}
+	clear(p)
+	r.n.Add(int64(len(p)))
+	return len(p), nil
+}
+
+func mbps(n int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(n) * 8 / elapsed.Seconds() / 1e6
+}
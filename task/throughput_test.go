@@ -0,0 +1,192 @@
+package task
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+
+	"github.com/peanut996/CloudflareWarpSpeedTest/utils"
+)
+
+// startTestPeer runs a WireGuard peer on loopback whose tunnel address
+// 10.9.0.1 serves HTTP with handler. It returns the peer's UDP endpoint and
+// its netstack, and configures PrivateKey/PublicKey so newTunnel can reach
+// it.
+func startTestPeer(t *testing.T, handler http.Handler) (*net.UDPAddr, *netstack.Net) {
+	t.Helper()
+	serverKey := newTestPrivateKey(t)
+	clientKey := newTestPrivateKey(t)
+	clientPub := publicKeyOf(clientKey)
+
+	tun, tnet, err := netstack.CreateNetTUN([]netip.Addr{netip.MustParseAddr("10.9.0.1")}, nil, tunnelMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bind := &reservedBind{Bind: conn.NewDefaultBind(), reserved: reserved}
+	dev := device.NewDevice(tun, bind, device.NewLogger(device.LogLevelSilent, ""))
+	t.Cleanup(dev.Close)
+	uapi := fmt.Sprintf("private_key=%s\nlisten_port=0\npublic_key=%s\nallowed_ip=10.9.0.2/32\n",
+		hex.EncodeToString(serverKey[:]), hex.EncodeToString(clientPub[:]))
+	if err := dev.IpcSet(uapi); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := tnet.ListenTCP(&net.TCPAddr{Port: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conns sync.WaitGroup
+	server := &http.Server{Handler: handler, ConnState: func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			conns.Add(1)
+		case http.StateClosed, http.StateHijacked:
+			conns.Done()
+		}
+	}}
+	go server.Serve(listener)
+	// shut down in the same order as tunnel.Close, once the client has
+	// closed its connections
+	t.Cleanup(func() {
+		closed := make(chan struct{})
+		go func() {
+			conns.Wait()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-time.After(tunnelCloseTimeout):
+		}
+		server.Close()
+		dev.Down()
+		dev.Close()
+	})
+
+	origPri, origPub, origAddr := PrivateKey, PublicKey, TunnelAddress
+	t.Cleanup(func() {
+		PrivateKey, PublicKey, TunnelAddress = origPri, origPub, origAddr
+	})
+	serverPub := publicKeyOf(serverKey)
+	PrivateKey = base64.StdEncoding.EncodeToString(clientKey[:])
+	PublicKey = base64.StdEncoding.EncodeToString(serverPub[:])
+	TunnelAddress = "10.9.0.2"
+
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: listenPort(t, dev)}, tnet
+}
+
+func listenPort(t *testing.T, dev *device.Device) int {
+	t.Helper()
+	state, err := dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(state, "\n") {
+		if v, ok := strings.CutPrefix(line, "listen_port="); ok {
+			port, err := strconv.Atoi(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return port
+		}
+	}
+	t.Fatal("device has no listen port")
+	return 0
+}
+
+// startSpeedTestPeer runs a test peer serving the download and upload
+// URLs, and points the speed test at them.
+func startSpeedTestPeer(t *testing.T) *net.UDPAddr {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/down", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 4<<20))
+	})
+	mux.HandleFunc("/up", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	})
+	endpoint, _ := startTestPeer(t, mux)
+
+	origDown, origUp, origDuration := DownloadURL, UploadURL, SpeedTestDuration
+	t.Cleanup(func() {
+		DownloadURL, UploadURL, SpeedTestDuration = origDown, origUp, origDuration
+	})
+	DownloadURL = "http://10.9.0.1/down"
+	UploadURL = "http://10.9.0.1/up"
+	SpeedTestDuration = 500 * time.Millisecond
+	return endpoint
+}
+
+func TestMeasureThroughput(t *testing.T) {
+	endpoint := startSpeedTestPeer(t)
+
+	down, up, err := measureThroughput(context.Background(), endpoint)
+	if err != nil {
+		t.Fatalf("measureThroughput() error = %v", err)
+	}
+	if down <= 0 {
+		t.Errorf("measureThroughput() download = %v, want > 0", down)
+	}
+	if up <= 0 {
+		t.Errorf("measureThroughput() upload = %v, want > 0", up)
+	}
+}
+
+func TestTestThroughput_SkipsDataPlaneFailed(t *testing.T) {
+	endpoint := startSpeedTestPeer(t)
+	origCount := SpeedTestCount
+	defer func() { SpeedTestCount = origCount }()
+	SpeedTestCount = 1
+
+	result := func(dataPlane utils.DataPlaneStatus) utils.CloudflareIPData {
+		return utils.CloudflareIPData{
+			PingData:  &utils.PingData{IP: endpoint, Status: utils.StatusOK, Protocol: ProbeModeWireGuard},
+			DataPlane: dataPlane,
+		}
+	}
+	ipSet := utils.PingDelaySet{result(utils.DataPlaneFailed), result(utils.DataPlaneOK)}
+
+	got := TestThroughput(context.Background(), ipSet)
+	if got[0].DownloadSpeed != 0 || got[0].UploadSpeed != 0 {
+		t.Errorf("TestThroughput() measured the endpoint that failed the data plane check: %v/%v Mbps", got[0].DownloadSpeed, got[0].UploadSpeed)
+	}
+	if got[1].DownloadSpeed <= 0 || got[1].UploadSpeed <= 0 {
+		t.Errorf("TestThroughput() = %v/%v Mbps for the next endpoint, want both > 0", got[1].DownloadSpeed, got[1].UploadSpeed)
+	}
+}
+
+func TestMbps(t *testing.T) {
+	tests := []struct {
+		name    string
+		n       int64
+		elapsed time.Duration
+		want    float64
+	}{
+		{name: "one megabit", n: 125000, elapsed: time.Second, want: 1},
+		{name: "zero elapsed", n: 125000, elapsed: 0, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mbps(tt.n, tt.elapsed); got != tt.want {
+				t.Errorf("mbps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package task
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+
+	"github.com/peanut996/CloudflareWarpSpeedTest/utils"
+)
+
+const (
+	defaultTunnelAddress = "172.16.0.2"
+	defaultTunnelDNS     = "1.1.1.1"
+	tunnelMTU            = 1280
+	// tunnelCloseTimeout bounds how long closing a connection waits for the
+	// peer to finish the TCP close.
+	tunnelCloseTimeout = 2 * time.Second
+)
+
+var (
+	TunnelAddress = defaultTunnelAddress
+)
+
+// tunnel is a userspace WireGuard interface backed by a gVisor netstack.
+type tunnel struct {
+	dev  *device.Device
+	tnet *netstack.Net
+
+	m     sync.Mutex
+	conns []*tunnelConn
+}
+
+// newTunnel brings up a WireGuard tunnel to endpoint using the configured
+// key pair and reserved bytes, routing everything through the peer.
+func newTunnel(endpoint *net.UDPAddr) (*tunnel, error) {
+	pri, err := getNoisePrivateKeyFromBase64(PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := getNoisePublicKeyFromBase64(PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := netip.ParseAddr(TunnelAddress)
+	if err != nil {
+		return nil, err
+	}
+	dns := netip.MustParseAddr(defaultTunnelDNS)
+
+	tun, tnet, err := netstack.CreateNetTUN([]netip.Addr{addr}, []netip.Addr{dns}, tunnelMTU)
+	if err != nil {
+		return nil, err
+	}
+	bind := &reservedBind{Bind: &probeBind{}, reserved: reserved}
+	dev := device.NewDevice(tun, bind, device.NewLogger(device.LogLevelSilent, ""))
+
+	var uapi strings.Builder
+	fmt.Fprintf(&uapi, "private_key=%s\n", hex.EncodeToString(pri[:]))
+	fmt.Fprintf(&uapi, "public_key=%s\n", hex.EncodeToString(pub[:]))
+	fmt.Fprintf(&uapi, "endpoint=%s\n", endpoint.String())
+	uapi.WriteString("allowed_ip=0.0.0.0/0\n")
+	uapi.WriteString("allowed_ip=::/0\n")
+	if err := dev.IpcSet(uapi.String()); err != nil {
+		dev.Close()
+		return nil, err
+	}
+	if err := dev.Up(); err != nil {
+		dev.Close()
+		return nil, err
+	}
+	return &tunnel{dev: dev, tnet: tnet}, nil
+}
+
+// DialContext connects to addr through the tunnel. The connections are
+// closed with the tunnel.
+func (t *tunnel) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := t.tnet.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &tunnelConn{Conn: conn}
+	t.m.Lock()
+	t.conns = append(t.conns, c)
+	t.m.Unlock()
+	return c, nil
+}
+
+// Close shuts the tunnel down in order: the connections are closed until
+// the peer has acknowledged, the device stops exchanging packets with the
+// peer, then the device and its netstack are closed. netstack closes the
+// channel gVisor writes outgoing packets to, so nothing may be left for
+// gVisor to send by then.
+func (t *tunnel) Close() {
+	t.m.Lock()
+	conns := t.conns
+	t.conns = nil
+	t.m.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+	t.dev.Down()
+	t.dev.Close()
+}
+
+// tunnelConn is a connection through a tunnel whose Close waits for the
+// TCP close to complete, instead of leaving gVisor to finish it while the
+// tunnel is torn down.
+type tunnelConn struct {
+	net.Conn
+	once sync.Once
+	err  error
+}
+
+func (c *tunnelConn) Close() error {
+	c.once.Do(func() {
+		if tcp, ok := c.Conn.(interface{ CloseWrite() error }); ok && tcp.CloseWrite() == nil {
+			// the peer closes its side once it has read our FIN
+			c.Conn.SetReadDeadline(time.Now().Add(tunnelCloseTimeout))
+			io.Copy(io.Discard, c.Conn)
+		}
+		c.err = c.Conn.Close()
+	})
+	return c.err
+}
+
+// probeBind is a conn.Bind on a single socket opened like those of the
+// handshake probes, so that the tunnel takes the same path: from the
+// source address and interface, through the SOCKS5 proxy if any.
+type probeBind struct {
+	m    sync.Mutex
+	sock *poolSocket
+}
+
+func (b *probeBind) Open(port uint16) ([]conn.ReceiveFunc, uint16, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.sock != nil {
+		return nil, 0, conn.ErrBindAlreadyOpen
+	}
+	sock, err := newPoolSocket()
+	if err != nil {
+		return nil, 0, err
+	}
+	b.sock = sock
+	return []conn.ReceiveFunc{b.receive(sock)}, uint16(sock.LocalAddr().(*net.UDPAddr).Port), nil
+}
+
+func (b *probeBind) receive(sock *poolSocket) conn.ReceiveFunc {
+	return func(packets [][]byte, sizes []int, eps []conn.Endpoint) (int, error) {
+		for {
+			data, from, ok, err := sock.readFrom(packets[0])
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				// decapsulated datagrams start after the SOCKS5 header
+				sizes[0] = copy(packets[0], data)
+				eps[0] = &conn.StdNetEndpoint{AddrPort: from}
+				return 1, nil
+			}
+		}
+	}
+}
+
+func (b *probeBind) Close() error {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.sock == nil {
+		return nil
+	}
+	err := b.sock.Close()
+	b.sock = nil
+	return err
+}
+
+func (b *probeBind) SetMark(uint32) error {
+	return nil
+}
+
+func (b *probeBind) Send(bufs [][]byte, ep conn.Endpoint) error {
+	b.m.Lock()
+	sock := b.sock
+	b.m.Unlock()
+	if sock == nil {
+		return net.ErrClosed
+	}
+	dst, ok := ep.(*conn.StdNetEndpoint)
+	if !ok {
+		return conn.ErrWrongEndpointType
+	}
+	for _, buf := range bufs {
+		if _, err := sock.writeTo(buf, dst.AddrPort); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *probeBind) ParseEndpoint(s string) (conn.Endpoint, error) {
+	addr, err := netip.ParseAddrPort(s)
+	if err != nil {
+		return nil, err
+	}
+	return &conn.StdNetEndpoint{AddrPort: unmapAddrPort(addr)}, nil
+}
+
+func (b *probeBind) BatchSize() int {
+	return 1
+}
+
+// reservedBind writes the WARP reserved bytes into every outgoing message
+// and clears them on receive, since wireguard-go reads the first four bytes
+// as the message type.
+type reservedBind struct {
+	conn.Bind
+	reserved [3]byte
+}
+
+func (b *reservedBind) Open(port uint16) ([]conn.ReceiveFunc, uint16, error) {
+	fns, actualPort, err := b.Bind.Open(port)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i, fn := range fns {
+		fns[i] = clearReserved(fn)
+	}
+	return fns, actualPort, nil
+}
+
+func (b *reservedBind) Send(bufs [][]byte, ep conn.Endpoint) error {
+	for _, buf := range bufs {
+		if len(buf) > 3 {
+			buf[1], buf[2], buf[3] = b.reserved[0], b.reserved[1], b.reserved[2]
+		}
+	}
+	return b.Bind.Send(bufs, ep)
+}
+
+func clearReserved(fn conn.ReceiveFunc) conn.ReceiveFunc {
+	return func(packets [][]byte, sizes []int, eps []conn.Endpoint) (int, error) {
+		n, err := fn(packets, sizes, eps)
+		for i := 0; i < n; i++ {
+			if sizes[i] > 3 {
+				packets[i][1], packets[i][2], packets[i][3] = 0, 0, 0
+			}
+		}
+		return n, err
+	}
+}
+
+// tunnelTargets returns the indexes of the first n endpoints of ipSet that
+// a WireGuard tunnel can be brought up to: those that answered the
+// WireGuard handshake, unless the data plane check already failed.
+func tunnelTargets(ipSet utils.PingDelaySet, n int) []int {
+	targets := make([]int, 0, n)
+	for i := range ipSet {
+		if len(targets) == n {
+			break
+		}
+		if ipSet[i].Status == utils.StatusOK && ipSet[i].Protocol != ProbeModeMasque && ipSet[i].DataPlane != utils.DataPlaneFailed {
+			targets = append(targets, i)
+		}
+	}
+	return targets
+}
+
+// BestEndpoints returns up to n of the best endpoints of ipSet for a
+// WireGuard client, leaving out those that failed the data plane check.
+func BestEndpoints(ipSet utils.PingDelaySet, n int) []*net.UDPAddr {
+	var endpoints []*net.UDPAddr
+	for _, i := range tunnelTargets(ipSet, n) {
+		endpoints = append(endpoints, ipSet[i].IP)
+	}
+	return endpoints
+}
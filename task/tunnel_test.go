@@ -0,0 +1,48 @@
+package task
+
+import (
+	"net"
+	"testing"
+
+	"github.com/peanut996/CloudflareWarpSpeedTest/utils"
+)
+
+func TestBestEndpoints(t *testing.T) {
+	result := func(ip string, status utils.ProbeStatus, protocol string, dataPlane utils.DataPlaneStatus) utils.CloudflareIPData {
+		return utils.CloudflareIPData{
+			PingData:  &utils.PingData{IP: &net.UDPAddr{IP: net.ParseIP(ip), Port: 2408}, Status: status, Protocol: protocol},
+			DataPlane: dataPlane,
+		}
+	}
+	ipSet := utils.PingDelaySet{
+		result("162.159.192.1", utils.StatusInvalidResponder, ProbeModeWireGuard, utils.DataPlaneUnchecked),
+		result("162.159.192.2", utils.StatusOK, ProbeModeMasque, utils.DataPlaneUnchecked),
+		result("162.159.192.3", utils.StatusOK, ProbeModeWireGuard, utils.DataPlaneFailed),
+		result("162.159.192.4", utils.StatusOK, ProbeModeWireGuard, utils.DataPlaneOK),
+		result("162.159.192.5", utils.StatusOK, ProbeModeWireGuard, utils.DataPlaneUnchecked),
+		result("162.159.192.6", utils.StatusOK, ProbeModeWireGuard, utils.DataPlaneUnchecked),
+	}
+
+	tests := []struct {
+		name string
+		n    int
+		want []string
+	}{
+		{name: "best only", n: 1, want: []string{"162.159.192.4:2408"}},
+		{name: "top two", n: 2, want: []string{"162.159.192.4:2408", "162.159.192.5:2408"}},
+		{name: "more than usable", n: 10, want: []string{"162.159.192.4:2408", "162.159.192.5:2408", "162.159.192.6:2408"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := BestEndpoints(ipSet, tt.n)
+			if len(got) != len(tt.want) {
+				t.Fatalf("BestEndpoints() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].String() != tt.want[i] {
+					t.Errorf("BestEndpoints()[%d] = %v, want %s", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
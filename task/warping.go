@@ -1,9 +1,8 @@
 package task
 
 import (
-	"bytes"
+	"context"
 	"encoding/base64"
-	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -20,21 +19,19 @@ import (
 
 	"golang.org/x/crypto/blake2s"
 	"golang.org/x/crypto/poly1305"
+	"golang.org/x/time/rate"
 	"golang.zx2c4.com/wireguard/tai64n"
 
 	"github.com/peanut996/CloudflareWarpSpeedTest/utils"
 
-	"golang.zx2c4.com/wireguard/conn"
 	"golang.zx2c4.com/wireguard/device"
-	"golang.zx2c4.com/wireguard/tun/netstack"
 )
 
 const (
 	defaultRoutines             = 200
 	defaultPingTimes            = 10
-	udpConnectTimeout           = time.Millisecond * 1000
-	wireguardHandshakeRespBytes = 92
-	warpPublicKey               = "bmXOC+F1FxEMF9dyiK2H5/1SUtzH0JuVo51h2wPfgyo="
+	wireguardHandshakeRespBytes = device.MessageResponseSize
+	WarpPublicKey               = "bmXOC+F1FxEMF9dyiK2H5/1SUtzH0JuVo51h2wPfgyo="
 )
 
 var (
@@ -56,6 +53,9 @@ var (
 
 	MaxScanCount = 5000
 
+	// ResultSinks receives every endpoint as soon as its probes finish.
+	ResultSinks utils.Sinks
+
 	ports = []int{
 		500, 854, 859, 864, 878, 880, 890, 891, 894, 903,
 		908, 928, 934, 939, 942, 943, 945, 946, 955, 968,
@@ -80,6 +80,18 @@ var (
 	}
 
 	warpHandshakePacket, _ = hex.DecodeString("013cbdafb4135cac96a29484d7a0175ab152dd3e59be35049beadf758b8d48af14ca65f25a168934746fe8bc8867b1c17113d71c0fac5c141ef9f35783ffa5357c9871f4a006662b83ad71245a862495376a5fe3b4f2e1f06974d748416670e5f9b086297f652e6dfbf742fbfc63c3d8aeb175a3e9b7582fbc67c77577e4c0b32b05f92900000000000000000000000000000000")
+
+	// identity builds a fresh initiation for every probe once a private key
+	// is configured. It is nil when the built-in packet is replayed.
+	identity *noiseIdentity
+)
+
+type probeStatus int
+
+const (
+	probeLost probeStatus = iota
+	probeOK
+	probeInvalid
 )
 
 type MessageInitiation struct {
@@ -99,24 +111,35 @@ type UDPAddr struct {
 }
 
 type Warping struct {
-	wg      *sync.WaitGroup
-	m       *sync.Mutex
-	ips     []*UDPAddr
-	csv     utils.PingDelaySet
-	control chan bool
-	bar     *utils.Bar
+	wg        *sync.WaitGroup
+	m         *sync.Mutex
+	ips       []*UDPAddr
+	csv       utils.PingDelaySet
+	available int
+	control   chan bool
+	bar       *utils.Bar
+	limiter   *rate.Limiter
+	prefixes  *prefixLimiter
+	pool      *socketPool
+	// masque replaces the socket pool in MASQUE mode
+	masque *masqueProber
 }
 
 func NewWarping() *Warping {
 	checkPingDefault()
+	loadProbeSource()
+	loadSocksProxy()
+	loadProbeMode()
 	ips := loadWarpIPRanges()
 	return &Warping{
-		wg:      &sync.WaitGroup{},
-		m:       &sync.Mutex{},
-		ips:     ips,
-		csv:     make(utils.PingDelaySet, 0),
-		control: make(chan bool, Routines),
-		bar:     utils.NewBar(len(ips), i18n.QueryI18n(i18n.Available), ""),
+		wg:       &sync.WaitGroup{},
+		m:        &sync.Mutex{},
+		ips:      ips,
+		csv:      make(utils.PingDelaySet, 0),
+		control:  make(chan bool, Routines),
+		bar:      utils.NewBar(len(ips), i18n.QueryI18n(i18n.Available), ""),
+		limiter:  newPacketLimiter(),
+		prefixes: newPrefixLimiter(),
 	}
 }
 
@@ -127,16 +150,45 @@ func checkPingDefault() {
 	if PingTimes <= 0 {
 		PingTimes = defaultPingTimes
 	}
+	if MaxProbeTimeout <= 0 {
+		MaxProbeTimeout = defaultMaxProbeTimeout
+	}
 }
 
-func (w *Warping) Run() utils.PingDelaySet {
+// Run probes every endpoint and returns the sorted results. Once ctx is done
+// no new probes are started; probes in flight finish and the endpoints
+// gathered so far are returned.
+func (w *Warping) Run(ctx context.Context) utils.PingDelaySet {
 	if len(w.ips) == 0 {
 		return w.csv
 	}
+	if ProbeMode == ProbeModeMasque {
+		prober, err := newMasqueProber()
+		if err != nil {
+			log.Fatalln(i18n.QueryI18n(i18n.SocketPoolFailed) + err.Error())
+		}
+		defer prober.Close()
+		w.masque = prober
+	} else {
+		pool, err := newSocketPool(socketPoolSize)
+		if err != nil {
+			log.Fatalln(i18n.QueryI18n(i18n.SocketPoolFailed) + err.Error())
+		}
+		defer pool.Close()
+		w.pool = pool
+	}
+
 	for _, ip := range w.ips {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case w.control <- false:
+		case <-ctx.Done():
+			continue
+		}
 		w.wg.Add(1)
-		w.control <- false
-		go w.start(ip)
+		go w.start(ctx, ip)
 	}
 	w.wg.Wait()
 	w.bar.Done()
@@ -144,29 +196,54 @@ func (w *Warping) Run() utils.PingDelaySet {
 	return w.csv
 }
 
-func (w *Warping) start(ip *UDPAddr) {
+func (w *Warping) start(ctx context.Context, ip *UDPAddr) {
 	defer w.wg.Done()
-	w.warpingHandler(ip)
+	w.warpingHandler(ctx, ip)
 	<-w.control
 }
 
-func (w *Warping) warpingHandler(ip *UDPAddr) {
-	recv, totalDelay := w.warping(ip)
-	nowAble := len(w.csv)
-	if recv != 0 {
-		nowAble++
-	}
-	w.bar.Grow(1, strconv.Itoa(nowAble))
-	if recv == 0 {
+func (w *Warping) warpingHandler(ctx context.Context, ip *UDPAddr) {
+	result := w.warping(ctx, ip)
+	rtts := result.rtts
+	recv := len(rtts)
+	if recv == 0 && result.invalid == 0 {
+		w.bar.Grow(1, strconv.Itoa(w.availableCount()))
 		return
 	}
 	data := &utils.PingData{
 		IP:       ip.ToUDPAddr(),
-		Sent:     PingTimes,
+		Sent:     result.sent,
 		Received: recv,
-		Delay:    totalDelay / time.Duration(recv),
+		RTTs:     rtts,
+		Outcomes: result.outcomes,
+		Source:   probeSource(),
+		Proxy:    probeProxy(),
+		Protocol: ProbeMode,
+
+		CookieChallenged: result.cookied,
+		Throttled:        result.throttled,
+		LimiterWait:      result.waited,
+	}
+	if recv == 0 {
+		data.Status = utils.StatusInvalidResponder
+	} else {
+		var totalDelay time.Duration
+		for _, rtt := range rtts {
+			totalDelay += rtt
+		}
+		data.Delay = totalDelay / time.Duration(recv)
+		data.LatencyStats = utils.NewLatencyStats(rtts)
+		data.LossStats = utils.NewLossStats(result.outcomes)
+		if len(result.connects) > 0 {
+			var total time.Duration
+			for _, connect := range result.connects {
+				total += connect
+			}
+			data.ConnectDelay = total / time.Duration(len(result.connects))
+		}
 	}
 	w.appendIPData(data)
+	w.bar.Grow(1, strconv.Itoa(w.availableCount()))
 }
 
 func (w *Warping) appendIPData(data *utils.PingData) {
@@ -175,11 +252,26 @@ func (w *Warping) appendIPData(data *utils.PingData) {
 	w.csv = append(w.csv, utils.CloudflareIPData{
 		PingData: data,
 	})
+	ResultSinks.Record(w.csv[len(w.csv)-1])
+	if data.Status == utils.StatusOK {
+		w.available++
+	}
+}
+
+func (w *Warping) availableCount() int {
+	w.m.Lock()
+	defer w.m.Unlock()
+	return w.available
 }
 
 func loadWarpIPRanges() (ipAddrs []*UDPAddr) {
-	ips := loadIPRanges()
-	addrs := generateIPAddrs(ips)
+	loadPorts()
+	ips, explicit := loadIPRanges()
+	addrs := append(generateIPAddrs(ips), explicit...)
+	shuffleAddrs(&addrs)
+	if DualStackMode {
+		return loadDualStack(addrs)
+	}
 	if !AllMode && len(addrs) > MaxScanCount {
 		return addrs[:MaxScanCount]
 	}
@@ -190,7 +282,6 @@ func generateIPAddrs(ips []*net.IPAddr) (udpAddrs []*UDPAddr) {
 	for _, port := range ports {
 		udpAddrs = append(udpAddrs, generateSingleIPAddr(ips, port)...)
 	}
-	shuffleAddrs(&udpAddrs)
 	return udpAddrs
 }
 
@@ -218,48 +309,133 @@ func (i *UDPAddr) ToUDPAddr() (addr *net.UDPAddr) {
 	return
 }
 
-func (w *Warping) warping(ip *UDPAddr) (received int, totalDelay time.Duration) {
-	fullAddress := ip.FullAddress()
-	con, err := net.DialTimeout("udp", fullAddress, udpConnectTimeout)
+func (i *UDPAddr) addrPort() netip.AddrPort {
+	ip, _ := netip.AddrFromSlice(i.IP.IP)
+	return netip.AddrPortFrom(ip.Unmap(), uint16(i.Port))
+}
+
+// endpointResult collects the handshake probes sent to one endpoint.
+type endpointResult struct {
+	sent    int
+	rtts    []time.Duration
+	invalid int
+	cookied int
+	// throttled counts probes delayed by the limiters, waited is the total
+	// delay
+	throttled int
+	waited    time.Duration
+	// connects holds the CONNECT-UDP setup times of MASQUE probes
+	connects []time.Duration
+	// outcomes holds whether each probe was answered
+	outcomes []bool
+}
+
+// probeResult is the outcome of a single handshake probe.
+type probeResult struct {
+	status  probeStatus
+	rtt     time.Duration
+	cookied bool
+	waited  time.Duration
+	// connect is the CONNECT-UDP setup time of a MASQUE probe
+	connect time.Duration
+}
+
+func (w *Warping) warping(ctx context.Context, ip *UDPAddr) (result endpointResult) {
+	// waiting for the prefix is charged to the first probe
+	waited, err := w.prefixes.acquire(ctx, ip.IP.IP)
 	if err != nil {
-		return 0, 0
+		return
 	}
-	defer con.Close()
+	defer w.prefixes.release(ip.IP.IP)
 
+	var con *probeConn
+	if w.pool != nil {
+		con = w.pool.conn(ip.addrPort())
+		defer con.Close()
+	}
+	var schedule probeScheduler
 	for i := 0; i < PingTimes; i++ {
-		ok, rtt := handshake(con)
-		if ok {
-			received++
-			totalDelay += rtt
+		if err := schedule.wait(ctx); err != nil {
+			break
+		}
+		var probe probeResult
+		if w.masque != nil {
+			probe, err = w.masque.handshake(ctx, ip.addrPort(), w.limiter, schedule.timeout())
+		} else {
+			probe, err = handshake(ctx, con, w.limiter, schedule.timeout())
+		}
+		if err != nil {
+			break
+		}
+		result.sent++
+		result.outcomes = append(result.outcomes, probe.status == probeOK)
+		waited += probe.waited
+		if waited > 0 {
+			result.throttled++
+			result.waited += waited
+			waited = 0
+		}
+		if probe.cookied {
+			result.cookied++
+		}
+		switch probe.status {
+		case probeOK:
+			result.rtts = append(result.rtts, probe.rtt)
+			if probe.connect > 0 {
+				result.connects = append(result.connects, probe.connect)
+			}
+			schedule.observe(probe.rtt)
+		case probeInvalid:
+			result.invalid++
 		}
 	}
 	return
 
 }
 
-func handshake(conn net.Conn) (bool, time.Duration) {
-	startTime := time.Now()
-	_, err := conn.Write(warpHandshakePacket)
+// handshake sends one probe to conn and waits up to timeout for each reply.
+// It only fails if ctx is done before the probe could be sent.
+func handshake(ctx context.Context, conn *probeConn, limiter *rate.Limiter, timeout time.Duration) (result probeResult, err error) {
+	probe, err := newProbePacket()
 	if err != nil {
-		return false, 0
+		return result, nil
 	}
-
-	revBuff := make([]byte, 1024)
-
-	err = conn.SetDeadline(time.Now().Add(udpConnectTimeout))
+	waited, err := throttle(ctx, limiter, probePackets())
 	if err != nil {
-		return false, 0
+		return result, err
+	}
+	result.waited += waited
+	if err := sendJunk(conn); err != nil {
+		return result, nil
 	}
-	n, err := conn.Read(revBuff)
+	reply, rtt, err := conn.exchange(probe.data, probe.sender(), timeout)
 	if err != nil {
-		return false, 0
+		return result, nil
+	}
+	if isCookieReply(reply) {
+		result.cookied = true
+		packet, ok := probe.answerCookieReply(reply)
+		if !ok {
+			result.status = probeInvalid
+			return result, nil
+		}
+		// the probe is already out, so the retry is not cancelled
+		waited, _ := throttle(context.Background(), limiter, probePackets())
+		result.waited += waited
+		if err := sendJunk(conn); err != nil {
+			return result, nil
+		}
+		reply, rtt, err = conn.exchange(packet, probe.sender(), timeout)
+		if err != nil {
+			return result, nil
+		}
 	}
-	if n != wireguardHandshakeRespBytes {
-		return false, 0
+	if !probe.verifyResponse(reply) {
+		result.status = probeInvalid
+		return result, nil
 	}
-
-	duration := time.Since(startTime)
-	return true, duration
+	result.status, result.rtt = probeOK, rtt
+	return result, nil
 }
 
 func shuffleAddrs(udpAddrs *[]*UDPAddr) {
@@ -271,9 +447,6 @@ func shuffleAddrs(udpAddrs *[]*UDPAddr) {
 
 func InitHandshakePacket() {
 	if ReservedString != "" {
-		if PrivateKey == "" {
-			log.Fatalln(i18n.QueryI18n(i18n.ReservedEmptyError))
-		}
 		r, err := utils.ParseReservedString(ReservedString)
 		if err != nil {
 			log.Fatalln(i18n.QueryI18n(i18n.ReservedParseError) + err.Error())
@@ -281,12 +454,22 @@ func InitHandshakePacket() {
 		reserved = r
 	}
 
+	initObfuscation()
+	if ReservedString != "" && !customHeaders() {
+		// the reserved bytes are left out of the MACs, so the built-in
+		// packet stays valid with the new ones
+		packet := make([]byte, len(warpHandshakePacket))
+		copy(packet, warpHandshakePacket)
+		AddReserved(packet)
+		warpHandshakePacket = packet
+	}
+
 	if PrivateKey == "" && PublicKey == "" {
 		return
 	}
 
 	if PublicKey == "" {
-		PublicKey = warpPublicKey
+		PublicKey = WarpPublicKey
 	}
 
 	pri, err := getNoisePrivateKeyFromBase64(PrivateKey)
@@ -299,41 +482,11 @@ func InitHandshakePacket() {
 		log.Fatalln(i18n.QueryI18n(i18n.PublicKeyParseError) + err.Error())
 	}
 
-	packet := buildHandshakePacket(pri, pub)
-
-	warpHandshakePacket = packet[:]
-}
-
-func buildHandshakePacket(pri device.NoisePrivateKey, pub device.NoisePublicKey) []byte {
-	d, _, err := netstack.CreateNetTUN([]netip.Addr{}, []netip.Addr{}, 1480)
+	id, err := newNoiseIdentity(pri, pub)
 	if err != nil {
 		log.Fatalln(i18n.QueryI18n(i18n.HandshakePacketBuildFailed) + err.Error())
 	}
-	dev := device.NewDevice(d, conn.NewDefaultBind(), device.NewLogger(0, ""))
-
-	dev.SetPrivateKey(pri)
-
-	peer, err := dev.NewPeer(pub)
-	if err != nil {
-		log.Fatalln(i18n.QueryI18n(i18n.HandshakePacketBuildFailed) + err.Error())
-	}
-	msg, err := dev.CreateMessageInitiation(peer)
-	if err != nil {
-		log.Fatalln(i18n.QueryI18n(i18n.HandshakePacketBuildFailed) + err.Error())
-	}
-
-	var buf [device.MessageInitiationSize]byte
-	writer := bytes.NewBuffer(buf[:0])
-
-	binary.Write(writer, binary.LittleEndian, msg)
-	packet := writer.Bytes()
-
-	generator := device.CookieGenerator{}
-	generator.Init(pub)
-	generator.AddMacs(packet)
-
-	AddReserved(packet)
-	return packet
+	identity = id
 }
 
 func AddReserved(packet []byte) {
@@ -1,11 +1,17 @@
 package task
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"net"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/peanut996/CloudflareWarpSpeedTest/emulator"
 	"github.com/peanut996/CloudflareWarpSpeedTest/utils"
+	"golang.zx2c4.com/wireguard/device"
 )
 
 func TestUDPAddr_FullAddress(t *testing.T) {
@@ -112,6 +118,38 @@ func TestWarping_AppendIPData(t *testing.T) {
 	}
 }
 
+// recordingSink keeps the endpoints recorded while scanning.
+type recordingSink struct {
+	recorded []utils.CloudflareIPData
+}
+
+func (s *recordingSink) Record(data utils.CloudflareIPData) {
+	s.recorded = append(s.recorded, data)
+}
+
+func (s *recordingSink) Write(utils.PingDelaySet) error { return nil }
+
+func (s *recordingSink) Close() error { return nil }
+
+func TestWarping_AppendIPData_Streams(t *testing.T) {
+	sink := &recordingSink{}
+	origSinks := ResultSinks
+	defer func() { ResultSinks = origSinks }()
+	ResultSinks = utils.Sinks{sink}
+
+	w := NewWarping()
+	pingData := &utils.PingData{
+		IP:       &net.UDPAddr{IP: net.ParseIP("162.159.192.1"), Port: 2408},
+		Sent:     10,
+		Received: 10,
+	}
+	w.appendIPData(pingData)
+
+	if len(sink.recorded) != 1 || sink.recorded[0].PingData != pingData {
+		t.Errorf("Warping.appendIPData() recorded %v, want the appended endpoint", sink.recorded)
+	}
+}
+
 func TestEncodeBase64ToHex(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -121,7 +159,7 @@ func TestEncodeBase64ToHex(t *testing.T) {
 	}{
 		{
 			name:    "valid base64",
-			key:     warpPublicKey,  // Use the actual WARP public key which is known to be valid
+			key:     WarpPublicKey,  // Use the actual WARP public key which is known to be valid
 			want:    "6e65ce0be17517110c17d77288ad87e7fd5252dcc7d09b95a39d61db03df832a",
 			wantErr: false,
 		},
@@ -161,7 +199,7 @@ func TestGetNoisePublicKeyFromBase64(t *testing.T) {
 	}{
 		{
 			name:    "valid warp public key",
-			key:     warpPublicKey,
+			key:     WarpPublicKey,
 			wantErr: false,
 		},
 		{
@@ -185,3 +223,170 @@ func TestGetNoisePublicKeyFromBase64(t *testing.T) {
 		})
 	}
 }
+
+func TestWarping_Run_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	w := NewWarping()
+	done := make(chan utils.PingDelaySet)
+	go func() { done <- w.Run(ctx) }()
+	select {
+	case got := <-done:
+		if len(got) != 0 {
+			t.Errorf("Warping.Run() with a cancelled context returned %v results, want 0", len(got))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Warping.Run() did not stop after its context was cancelled")
+	}
+}
+
+func TestWarping_Run_Emulator(t *testing.T) {
+	serverKey := newTestPrivateKey(t)
+	clientKey := newTestPrivateKey(t)
+	profiles := []emulator.Profile{
+		{Latency: 20 * time.Millisecond, Jitter: 5 * time.Millisecond},
+		{Latency: 10 * time.Millisecond, CookieReply: true},
+		{Garbage: true},
+		{Loss: 1},
+		{WrongReceiver: true},
+	}
+	em, err := emulator.Start(emulator.Config{PrivateKey: serverKey, Seed: 1, Endpoints: profiles})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer em.Close()
+
+	var targets []string
+	for _, addr := range em.Addrs() {
+		targets = append(targets, addr.String())
+	}
+	origIPText, origPri, origPub := IPText, PrivateKey, PublicKey
+	origPingTimes, origTimeout, origIdentity := PingTimes, MaxProbeTimeout, identity
+	defer func() {
+		IPText, PrivateKey, PublicKey = origIPText, origPri, origPub
+		PingTimes, MaxProbeTimeout, identity = origPingTimes, origTimeout, origIdentity
+	}()
+	serverPub := publicKeyOf(serverKey)
+	IPText = strings.Join(targets, ",")
+	PrivateKey = base64.StdEncoding.EncodeToString(clientKey[:])
+	PublicKey = base64.StdEncoding.EncodeToString(serverPub[:])
+	PingTimes = 4
+	MaxProbeTimeout = 200 * time.Millisecond
+	InitHandshakePacket()
+
+	results := make(map[string]utils.CloudflareIPData)
+	for _, data := range NewWarping().Run(context.Background()) {
+		results[data.IP.String()] = data
+	}
+
+	if got, ok := results[targets[0]]; !ok || got.Status != utils.StatusOK || got.Received != 4 || len(got.Outcomes) != 4 || got.LossBursts != 0 {
+		t.Errorf("healthy endpoint result = %+v, want 4 valid responses", got.PingData)
+	}
+	if got, ok := results[targets[1]]; !ok || got.Status != utils.StatusOK || got.CookieChallenged != 4 {
+		t.Errorf("loaded endpoint result = %+v, want 4 answered cookie challenges", got.PingData)
+	}
+	if got, ok := results[targets[2]]; !ok || got.Status != utils.StatusInvalidResponder {
+		t.Errorf("garbage endpoint result = %+v, want an invalid responder", got.PingData)
+	}
+	if _, ok := results[targets[3]]; ok {
+		t.Error("silent endpoint should not be reported")
+	}
+	if got, ok := results[targets[4]]; !ok || got.Status != utils.StatusInvalidResponder {
+		t.Errorf("wrong receiver endpoint result = %+v, want an invalid responder", got.PingData)
+	}
+}
+
+func TestWarping_Run_LateReplies(t *testing.T) {
+	// most replies arrive after their probe timed out, while the next probe
+	// to the endpoint is waiting
+	profiles := []emulator.Profile{{Latency: 150 * time.Millisecond, Jitter: 100 * time.Millisecond}}
+	em, err := emulator.Start(emulator.Config{
+		PrivateKey:  newTestPrivateKey(t),
+		ForeignKeys: []device.NoisePublicKey{builtinPublicKey()},
+		Seed:        1,
+		Endpoints:   profiles,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer em.Close()
+
+	origIPText, origPri, origPub := IPText, PrivateKey, PublicKey
+	origPingTimes, origTimeout, origIdentity := PingTimes, MaxProbeTimeout, identity
+	defer func() {
+		IPText, PrivateKey, PublicKey = origIPText, origPri, origPub
+		PingTimes, MaxProbeTimeout, identity = origPingTimes, origTimeout, origIdentity
+	}()
+	IPText = em.Addrs()[0].String()
+	PrivateKey, PublicKey, identity = "", "", nil
+	PingTimes = 12
+	MaxProbeTimeout = 100 * time.Millisecond
+	InitHandshakePacket()
+
+	results := NewWarping().Run(context.Background())
+	if len(results) != 1 || results[0].Received == 0 {
+		t.Fatalf("Warping.Run() = %v, want the endpoint with some replies", results)
+	}
+	if got := results[0]; got.MinDelay < 45*time.Millisecond || got.Received == got.Sent {
+		t.Errorf("Warping.Run() min RTT = %v with %d/%d replies, want late replies counted as lost", got.MinDelay, got.Received, got.Sent)
+	}
+}
+
+func TestWarping_Run_CancelledMidEndpoint(t *testing.T) {
+	em, err := emulator.Start(emulator.Config{
+		PrivateKey:  newTestPrivateKey(t),
+		ForeignKeys: []device.NoisePublicKey{builtinPublicKey()},
+		Endpoints:   []emulator.Profile{{Loss: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer em.Close()
+
+	origIPText, origPri, origPub := IPText, PrivateKey, PublicKey
+	origPingTimes, origTimeout, origIdentity := PingTimes, MaxProbeTimeout, identity
+	defer func() {
+		IPText, PrivateKey, PublicKey = origIPText, origPri, origPub
+		PingTimes, MaxProbeTimeout, identity = origPingTimes, origTimeout, origIdentity
+	}()
+	IPText = em.Addrs()[0].String()
+	PrivateKey, PublicKey, identity = "", "", nil
+	// all probes take 5s
+	PingTimes = 50
+	MaxProbeTimeout = 100 * time.Millisecond
+	InitHandshakePacket()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	NewWarping().Run(ctx)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Warping.Run() took %v after its context was cancelled mid-endpoint, want it to stop probing", elapsed)
+	}
+}
+
+func TestInitHandshakePacket_ReservedWithoutPrivateKey(t *testing.T) {
+	origPacket, origReserved, origString := warpHandshakePacket, reserved, ReservedString
+	origPri, origPub, origIdentity := PrivateKey, PublicKey, identity
+	defer func() {
+		warpHandshakePacket, reserved, ReservedString = origPacket, origReserved, origString
+		PrivateKey, PublicKey, identity = origPri, origPub, origIdentity
+	}()
+	PrivateKey, PublicKey, identity = "", "", nil
+	ReservedString = "0x010203"
+	InitHandshakePacket()
+
+	if identity != nil {
+		t.Error("InitHandshakePacket() built an identity without a private key")
+	}
+	if got := warpHandshakePacket[1:4]; !bytes.Equal(got, []byte{1, 2, 3}) {
+		t.Errorf("built-in packet reserved = %v, want [1 2 3]", got)
+	}
+	if !bytes.Equal(warpHandshakePacket[4:], origPacket[4:]) {
+		t.Error("InitHandshakePacket() changed more than the reserved bytes")
+	}
+	if !bytes.Equal(origPacket[1:4], []byte{60, 189, 175}) {
+		t.Error("InitHandshakePacket() modified the original built-in packet in place")
+	}
+}
@@ -3,10 +3,10 @@ package utils
 import (
 	"encoding/csv"
 	"fmt"
-	"log"
+	"io"
 	"net"
-	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/peanut996/CloudflareWarpSpeedTest/i18n"
@@ -17,22 +17,80 @@ const (
 	maxDelay              = 9999 * time.Millisecond
 	minDelay              = 0 * time.Millisecond
 	maxLossRate   float32 = 1.0
+	// maxLossPattern disables the loss pattern filters.
+	maxLossPattern = 9999
 )
 
 var (
 	InputMaxDelay    = maxDelay
 	InputMinDelay    = minDelay
 	InputMaxLossRate = maxLossRate
-	Output           = defaultOutput
-	PrintNum         = 10
+	InputMaxJitter   = maxDelay
+	InputMaxStdDev   = maxDelay
+	InputMaxP90Delay = maxDelay
+	InputMaxP99Delay = maxDelay
+	// InputMaxLossRun, InputMaxLossBursts and InputMaxBurstRatio are upper
+	// limits on the LossStats of an endpoint.
+	InputMaxLossRun    = maxLossPattern
+	InputMaxLossBursts = maxLossPattern
+	InputMaxBurstRatio = float64(maxLossPattern)
+	Output             = defaultOutput
+	PrintNum           = 10
 )
 
 func NoPrintResult() bool {
 	return PrintNum == 0
 }
 
-func noOutput() bool {
-	return Output == "" || Output == " "
+// ProbeStatus describes how an endpoint answered the handshake probes.
+type ProbeStatus int
+
+const (
+	StatusOK ProbeStatus = iota
+	// StatusInvalidResponder marks endpoints that replied, but never with a
+	// valid WireGuard handshake response.
+	StatusInvalidResponder
+)
+
+func (s ProbeStatus) String() string {
+	switch s {
+	case StatusInvalidResponder:
+		return "invalid responder"
+	default:
+		return "ok"
+	}
+}
+
+func (s ProbeStatus) localized() string {
+	switch s {
+	case StatusInvalidResponder:
+		return i18n.QueryI18n(i18n.StatusInvalidResponder)
+	default:
+		return i18n.QueryI18n(i18n.StatusOK)
+	}
+}
+
+// DataPlaneStatus is the outcome of sending traffic through a tunnel to an
+// endpoint after its handshake succeeded.
+type DataPlaneStatus int
+
+const (
+	DataPlaneUnchecked DataPlaneStatus = iota
+	DataPlaneOK
+	// DataPlaneFailed marks endpoints that complete the handshake but do
+	// not carry traffic.
+	DataPlaneFailed
+)
+
+func (s DataPlaneStatus) String() string {
+	switch s {
+	case DataPlaneOK:
+		return "ok"
+	case DataPlaneFailed:
+		return "failed"
+	default:
+		return "-"
+	}
 }
 
 type PingData struct {
@@ -40,11 +98,44 @@ type PingData struct {
 	Sent     int
 	Received int
 	Delay    time.Duration
+	Status   ProbeStatus
+	// RTTs holds every successful round-trip time in probe order.
+	RTTs []time.Duration
+	LatencyStats
+	// Outcomes holds whether each probe was answered, in probe order.
+	Outcomes []bool
+	LossStats
+	// CookieChallenged counts probes answered with a cookie reply, which
+	// endpoints send when under load.
+	CookieChallenged int
+	// Throttled counts probes that waited on the packet rate or per-prefix
+	// limiter, LimiterWait is the total time they waited.
+	Throttled   int
+	LimiterWait time.Duration
+	// Source is the local address or interface the probes were bound to,
+	// empty for the default route.
+	Source string
+	// Proxy is the SOCKS5 proxy the probes were relayed through, whose hop
+	// is included in every RTT.
+	Proxy string
+	// Protocol is the probe mode, "wireguard" or "masque".
+	Protocol string
+	// ConnectDelay is the mean HTTP/3 CONNECT-UDP setup time of MASQUE
+	// probes, on top of the QUIC handshake measured by Delay.
+	ConnectDelay time.Duration
 }
 
 type CloudflareIPData struct {
 	*PingData
 	lossRate float32
+	// DownloadSpeed and UploadSpeed are measured through a WireGuard
+	// tunnel, in Mbps.
+	DownloadSpeed float64
+	UploadSpeed   float64
+	// DataPlane and DataPlaneRTT are the result of the data plane check,
+	// DataPlaneRTT being the round trip inside the tunnel.
+	DataPlane    DataPlaneStatus
+	DataPlaneRTT time.Duration
 }
 
 func (cf *CloudflareIPData) getLossRate() float32 {
@@ -56,27 +147,72 @@ func (cf *CloudflareIPData) getLossRate() float32 {
 }
 
 func (cf *CloudflareIPData) toString() []string {
-	result := make([]string, 3)
+	result := make([]string, 27)
 	result[0] = cf.IP.String()
 	result[1] = strconv.FormatFloat(float64(cf.getLossRate())*100, 'f', 0, 32) + "%"
-	result[2] = strconv.FormatFloat(cf.Delay.Seconds()*1000, 'f', 2, 32)
+	result[2] = formatDelay(cf.Delay)
+	if cf.Status != StatusOK {
+		result[2] = "-"
+	}
+	result[3] = cf.Status.String()
+	result[4] = formatDelay(cf.MinDelay)
+	result[5] = formatDelay(cf.MaxDelay)
+	result[6] = formatDelay(cf.MedianDelay)
+	result[7] = formatDelay(cf.P90Delay)
+	result[8] = formatDelay(cf.P99Delay)
+	result[9] = formatDelay(cf.StdDev)
+	result[10] = formatDelay(cf.Jitter)
+	result[11] = strconv.FormatFloat(cf.DownloadSpeed, 'f', 2, 64)
+	result[12] = strconv.FormatFloat(cf.UploadSpeed, 'f', 2, 64)
+	result[13] = strconv.Itoa(cf.CookieChallenged)
+	result[14] = strconv.Itoa(cf.Throttled)
+	result[15] = formatDelay(cf.LimiterWait)
+	result[16] = cf.DataPlane.String()
+	result[17] = "-"
+	if cf.DataPlane == DataPlaneOK {
+		result[17] = formatDelay(cf.DataPlaneRTT)
+	}
+	result[18] = cf.Family()
+	result[19] = cf.Source
+	result[20] = cf.Proxy
+	result[21] = cf.Protocol
+	result[22] = "-"
+	if cf.ConnectDelay > 0 {
+		result[22] = formatDelay(cf.ConnectDelay)
+	}
+	result[23] = strconv.Itoa(cf.MaxLossRun)
+	result[24] = strconv.Itoa(cf.LossBursts)
+	result[25] = strconv.FormatFloat(cf.BurstRatio, 'f', 2, 64)
+	result[26] = formatOutcomes(cf.Outcomes)
 	return result
 }
 
-func ExportCsv(data []CloudflareIPData) {
-	if noOutput() || len(data) == 0 {
-		return
-	}
-	fp, err := os.Create(Output)
-	if err != nil {
-		log.Fatalf(i18n.QueryTemplateI18n(i18n.CreateFileFailed, map[string]interface{}{"Output": Output, "err": err}))
-		return
+// formatOutcomes renders the probe sequence with "+" for an answered
+// probe and "." for a lost one.
+func formatOutcomes(outcomes []bool) string {
+	b := make([]byte, len(outcomes))
+	for i, ok := range outcomes {
+		b[i] = '.'
+		if ok {
+			b[i] = '+'
+		}
 	}
-	defer fp.Close()
-	w := csv.NewWriter(fp)
-	_ = w.Write([]string{"IP:Port", "Loss", "Latency"})
-	_ = w.WriteAll(convertToString(data))
-	w.Flush()
+	return string(b)
+}
+
+func formatDelay(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds()*1000, 'f', 2, 32)
+}
+
+// resultHeader names the columns of CloudflareIPData.toString.
+var resultHeader = []string{"IP:Port", "Loss", "Latency", "Status", "Min", "Max", "Median", "P90", "P99", "StdDev", "Jitter", "Download Mbps", "Upload Mbps", "Cookie Challenged", "Throttled", "Limiter Wait", "Data Plane", "Data Plane RTT", "Family", "Source", "Proxy", "Protocol", "Connect Time", "Max Loss Run", "Loss Bursts", "Burst Ratio", "Probe Pattern"}
+
+func writeCSV(w io.Writer, data PingDelaySet) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write(resultHeader)
+	_ = cw.WriteAll(convertToString(data))
+	cw.Flush()
+	return cw.Error()
 }
 
 func convertToString(data []CloudflareIPData) [][]string {
@@ -121,6 +257,40 @@ func (s PingDelaySet) FilterLossRate() (data PingDelaySet) {
 	return
 }
 
+// FilterLatencyStats drops endpoints whose jitter, standard deviation or
+// tail latency exceed the configured upper limits.
+func (s PingDelaySet) FilterLatencyStats() (data PingDelaySet) {
+	if InputMaxJitter >= maxDelay && InputMaxStdDev >= maxDelay &&
+		InputMaxP90Delay >= maxDelay && InputMaxP99Delay >= maxDelay {
+		return s
+	}
+	for _, v := range s {
+		if v.Jitter > InputMaxJitter || v.StdDev > InputMaxStdDev ||
+			v.P90Delay > InputMaxP90Delay || v.P99Delay > InputMaxP99Delay {
+			continue
+		}
+		data = append(data, v)
+	}
+	return
+}
+
+// FilterLossPattern drops endpoints whose losses come in longer runs, in
+// more bursts or more clustered than the configured upper limits.
+func (s PingDelaySet) FilterLossPattern() (data PingDelaySet) {
+	if InputMaxLossRun >= maxLossPattern && InputMaxLossBursts >= maxLossPattern &&
+		InputMaxBurstRatio >= maxLossPattern {
+		return s
+	}
+	for _, v := range s {
+		if v.MaxLossRun > InputMaxLossRun || v.LossBursts > InputMaxLossBursts ||
+			v.BurstRatio > InputMaxBurstRatio {
+			continue
+		}
+		data = append(data, v)
+	}
+	return
+}
+
 func (s PingDelaySet) Len() int {
 	return len(s)
 }
@@ -147,19 +317,23 @@ func (s PingDelaySet) Print() {
 	if len(dataString) < PrintNum {
 		PrintNum = len(dataString)
 	}
-	headFormat := "\n%-24s%-9s%-10s\n"
-	dataFormat := "%-25s%-8s%-10s\n"
+	headFormat := "\n%-24s%-9s%-10s%s\n"
+	dataFormat := "%-25s%-8s%-10s%s\n"
 	for i := 0; i < PrintNum; i++ {
 		if len(dataString[i][0]) > 15 {
-			headFormat = "\n%-44s%-9s%-10s\n"
-			dataFormat = "%-45s%-8s%-10s\n"
+			headFormat = "\n%-44s%-9s%-10s%s\n"
+			dataFormat = "%-45s%-8s%-10s%s\n"
 		}
 	}
-	fmt.Printf(headFormat, "IP:Port", i18n.QueryI18n(i18n.PacketLossRate), i18n.QueryI18n(i18n.Latency))
+	fmt.Printf(headFormat, "IP:Port", i18n.QueryI18n(i18n.PacketLossRate), i18n.QueryI18n(i18n.Latency), i18n.QueryI18n(i18n.Status))
 	for i := 0; i < PrintNum; i++ {
-		fmt.Printf(dataFormat, dataString[i][0], dataString[i][1], dataString[i][2])
+		status := s[i].Status.localized()
+		if s[i].DataPlane == DataPlaneFailed {
+			status += " " + i18n.QueryI18n(i18n.DataPlaneFailedNote)
+		}
+		fmt.Printf(dataFormat, dataString[i][0], dataString[i][1], dataString[i][2], status)
 	}
-	if !noOutput() {
-		fmt.Println(i18n.QueryTemplateI18n(i18n.WriteResultToFileDone, map[string]interface{}{"Output": Output}))
+	if files := outputFiles(); len(files) > 0 {
+		fmt.Println(i18n.QueryTemplateI18n(i18n.WriteResultToFileDone, map[string]interface{}{"Output": strings.Join(files, ", ")}))
 	}
 }
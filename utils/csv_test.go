@@ -141,3 +141,78 @@ func TestPingDelaySet_FilterLossRate(t *testing.T) {
 		})
 	}
 }
+
+func TestCloudflareIPData_toString(t *testing.T) {
+	testIP, _ := net.ResolveUDPAddr("udp", "1.1.1.1:2408")
+
+	tests := []struct {
+		name     string
+		pingData *PingData
+		want     []string
+	}{
+		{
+			name:     "valid endpoint",
+			pingData: &PingData{IP: testIP, Sent: 10, Received: 10, Delay: 20 * time.Millisecond},
+			want:     []string{"1.1.1.1:2408", "0%", "20.00", "ok"},
+		},
+		{
+			name:     "invalid responder",
+			pingData: &PingData{IP: testIP, Sent: 10, Received: 0, Status: StatusInvalidResponder},
+			want:     []string{"1.1.1.1:2408", "100%", "-", "invalid responder"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cf := &CloudflareIPData{PingData: tt.pingData}
+			got := cf.toString()
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("CloudflareIPData.toString()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPingDelaySet_FilterLatencyStats(t *testing.T) {
+	origJitter, origP99 := InputMaxJitter, InputMaxP99Delay
+	defer func() {
+		InputMaxJitter, InputMaxP99Delay = origJitter, origP99
+	}()
+
+	InputMaxJitter = 5 * time.Millisecond
+	InputMaxP99Delay = 100 * time.Millisecond
+
+	testIP, _ := net.ResolveUDPAddr("udp", "1.1.1.1:0")
+	set := PingDelaySet{
+		{PingData: &PingData{IP: testIP, LatencyStats: LatencyStats{Jitter: 1 * time.Millisecond, P99Delay: 50 * time.Millisecond}}},
+		{PingData: &PingData{IP: testIP, LatencyStats: LatencyStats{Jitter: 10 * time.Millisecond, P99Delay: 50 * time.Millisecond}}},
+		{PingData: &PingData{IP: testIP, LatencyStats: LatencyStats{Jitter: 1 * time.Millisecond, P99Delay: 150 * time.Millisecond}}},
+	}
+
+	if got := set.FilterLatencyStats(); len(got) != 1 {
+		t.Errorf("PingDelaySet.FilterLatencyStats() returned %v items, want 1", len(got))
+	}
+}
+
+func TestPingDelaySet_FilterLossPattern(t *testing.T) {
+	origRun, origRatio := InputMaxLossRun, InputMaxBurstRatio
+	defer func() {
+		InputMaxLossRun, InputMaxBurstRatio = origRun, origRatio
+	}()
+
+	InputMaxLossRun = 2
+	InputMaxBurstRatio = 1.5
+
+	testIP, _ := net.ResolveUDPAddr("udp", "1.1.1.1:0")
+	set := PingDelaySet{
+		{PingData: &PingData{IP: testIP, LossStats: LossStats{MaxLossRun: 1, LossBursts: 3, BurstRatio: 0.8}}},
+		{PingData: &PingData{IP: testIP, LossStats: LossStats{MaxLossRun: 5, LossBursts: 1, BurstRatio: 1.2}}},
+		{PingData: &PingData{IP: testIP, LossStats: LossStats{MaxLossRun: 2, LossBursts: 1, BurstRatio: 2}}},
+	}
+
+	if got := set.FilterLossPattern(); len(got) != 1 {
+		t.Errorf("PingDelaySet.FilterLossPattern() returned %v items, want 1", len(got))
+	}
+}
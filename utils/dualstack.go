@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/peanut996/CloudflareWarpSpeedTest/i18n"
+)
+
+const (
+	FamilyIPv4 = "IPv4"
+	FamilyIPv6 = "IPv6"
+
+	// defaultIPv6Bias follows the resolution delay of Happy Eyeballs v2
+	// (RFC 8305).
+	defaultIPv6Bias = 50 * time.Millisecond
+)
+
+// IPv6Bias is the latency head start the best IPv6 endpoint gets over the
+// best IPv4 one when a single endpoint is recommended.
+var IPv6Bias = defaultIPv6Bias
+
+// Family returns FamilyIPv4 or FamilyIPv6.
+func (cf *CloudflareIPData) Family() string {
+	if cf.IP.IP.To4() != nil {
+		return FamilyIPv4
+	}
+	return FamilyIPv6
+}
+
+// BestPerFamily returns the first usable endpoint of each family in the
+// sorted set s, skipping endpoints that failed the data plane check. Either
+// result is nil when s has no usable endpoint of that family.
+func (s PingDelaySet) BestPerFamily() (v4, v6 *CloudflareIPData) {
+	for i := range s {
+		if s[i].Status != StatusOK || s[i].DataPlane == DataPlaneFailed {
+			continue
+		}
+		if s[i].Family() == FamilyIPv4 {
+			if v4 == nil {
+				v4 = &s[i]
+			}
+		} else if v6 == nil {
+			v6 = &s[i]
+		}
+		if v4 != nil && v6 != nil {
+			break
+		}
+	}
+	return
+}
+
+// Recommend picks one of the best endpoints of both families. The lower
+// loss rate wins; on a tie IPv6 is preferred unless it is slower than IPv4
+// by more than IPv6Bias.
+func Recommend(v4, v6 *CloudflareIPData) *CloudflareIPData {
+	if v4 == nil || v6 == nil {
+		if v6 != nil {
+			return v6
+		}
+		return v4
+	}
+	if v4Loss, v6Loss := v4.getLossRate(), v6.getLossRate(); v4Loss != v6Loss {
+		if v6Loss < v4Loss {
+			return v6
+		}
+		return v4
+	}
+	if v6.Delay-IPv6Bias <= v4.Delay {
+		return v6
+	}
+	return v4
+}
+
+// PrintDualStack prints the best endpoint of each family and the
+// recommended one.
+func (s PingDelaySet) PrintDualStack() {
+	if NoPrintResult() || len(s) == 0 {
+		return
+	}
+	v4, v6 := s.BestPerFamily()
+	fmt.Println()
+	printBest(i18n.QueryI18n(i18n.BestIPv4), v4)
+	printBest(i18n.QueryI18n(i18n.BestIPv6), v6)
+	printBest(i18n.QueryI18n(i18n.RecommendedEndpoint), Recommend(v4, v6))
+}
+
+func printBest(label string, data *CloudflareIPData) {
+	if data == nil {
+		fmt.Printf("%-14s-\n", label)
+		return
+	}
+	fmt.Printf("%-14s%-46s%s ms  %s\n", label, data.IP.String(), formatDelay(data.Delay), data.Family())
+}
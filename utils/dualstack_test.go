@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestIPData(addr string, received int, delay time.Duration) CloudflareIPData {
+	ip, _ := net.ResolveUDPAddr("udp", addr)
+	return CloudflareIPData{PingData: &PingData{IP: ip, Sent: 10, Received: received, Delay: delay}}
+}
+
+func TestPingDelaySet_BestPerFamily(t *testing.T) {
+	set := PingDelaySet{
+		newTestIPData("162.159.192.1:2408", 10, 10*time.Millisecond),
+		newTestIPData("[2606:4700:d0::1]:2408", 10, 20*time.Millisecond),
+		newTestIPData("162.159.192.2:2408", 10, 30*time.Millisecond),
+		newTestIPData("[2606:4700:d0::2]:2408", 10, 40*time.Millisecond),
+	}
+	set[0].DataPlane = DataPlaneFailed
+
+	v4, v6 := set.BestPerFamily()
+	if v4 != &set[2] {
+		t.Errorf("BestPerFamily() v4 = %v, want %v", v4.IP, set[2].IP)
+	}
+	if v6 != &set[1] {
+		t.Errorf("BestPerFamily() v6 = %v, want %v", v6.IP, set[1].IP)
+	}
+
+	v4, v6 = set[1:2].BestPerFamily()
+	if v4 != nil || v6 == nil {
+		t.Errorf("BestPerFamily() on IPv6 only = %v, %v, want nil, IPv6", v4, v6)
+	}
+}
+
+func TestRecommend(t *testing.T) {
+	origBias := IPv6Bias
+	defer func() { IPv6Bias = origBias }()
+	IPv6Bias = 50 * time.Millisecond
+
+	tests := []struct {
+		name string
+		v4   CloudflareIPData
+		v6   CloudflareIPData
+		want string
+	}{
+		{
+			name: "ipv6 within bias",
+			v4:   newTestIPData("162.159.192.1:2408", 10, 100*time.Millisecond),
+			v6:   newTestIPData("[2606:4700:d0::1]:2408", 10, 140*time.Millisecond),
+			want: FamilyIPv6,
+		},
+		{
+			name: "ipv6 beyond bias",
+			v4:   newTestIPData("162.159.192.1:2408", 10, 100*time.Millisecond),
+			v6:   newTestIPData("[2606:4700:d0::1]:2408", 10, 160*time.Millisecond),
+			want: FamilyIPv4,
+		},
+		{
+			name: "lower loss wins",
+			v4:   newTestIPData("162.159.192.1:2408", 10, 100*time.Millisecond),
+			v6:   newTestIPData("[2606:4700:d0::1]:2408", 9, 10*time.Millisecond),
+			want: FamilyIPv4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Recommend(&tt.v4, &tt.v6); got.Family() != tt.want {
+				t.Errorf("Recommend() = %v, want %v", got.Family(), tt.want)
+			}
+		})
+	}
+
+	only := newTestIPData("162.159.192.1:2408", 10, time.Millisecond)
+	if got := Recommend(&only, nil); got != &only {
+		t.Errorf("Recommend() without IPv6 = %v, want the IPv4 endpoint", got)
+	}
+	if got := Recommend(nil, nil); got != nil {
+		t.Errorf("Recommend() without endpoints = %v, want nil", got)
+	}
+}
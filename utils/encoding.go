@@ -1,28 +1,69 @@
 package utils
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/peanut996/CloudflareWarpSpeedTest/i18n"
 )
 
+// ParseReservedString parses the WARP reserved bytes given as a base64
+// client_id ("PL2v"), hex ("0x3cbdaf"), comma separated decimals
+// ("60,189,175") or a JSON array ("[60, 189, 175]").
 func ParseReservedString(reservedString string) (reserved [3]byte, err error) {
-	if reservedString == "" {
+	s := strings.TrimSpace(reservedString)
+	if s == "" {
 		return
 	}
-	
-	// First unmarshal into a slice to validate length
-	var tempSlice []byte
-	if err = json.Unmarshal([]byte(reservedString), &tempSlice); err != nil {
-		return
+
+	var values []int
+	switch {
+	case strings.HasPrefix(s, "["):
+		if json.Unmarshal([]byte(s), &values) != nil {
+			return reserved, errors.New(i18n.QueryI18n(i18n.ReservedFormatInvalid) + reservedString)
+		}
+	case strings.HasPrefix(s, "0x"), strings.HasPrefix(s, "0X"):
+		b, decodeErr := hex.DecodeString(s[2:])
+		if decodeErr != nil {
+			return reserved, errors.New(i18n.QueryI18n(i18n.ReservedFormatInvalid) + reservedString)
+		}
+		values = bytesToInts(b)
+	case strings.Contains(s, ","):
+		for _, part := range strings.Split(s, ",") {
+			v, convErr := strconv.Atoi(strings.TrimSpace(part))
+			if convErr != nil {
+				return reserved, errors.New(i18n.QueryI18n(i18n.ReservedByteInvalid) + part)
+			}
+			values = append(values, v)
+		}
+	default:
+		b, decodeErr := base64.StdEncoding.DecodeString(s)
+		if decodeErr != nil {
+			return reserved, errors.New(i18n.QueryI18n(i18n.ReservedFormatInvalid) + reservedString)
+		}
+		values = bytesToInts(b)
 	}
-	
-	// Validate length
-	if len(tempSlice) != 3 {
-		err = fmt.Errorf("reserved array must have exactly 3 elements, got %d", len(tempSlice))
-		return
+
+	if len(values) != 3 {
+		return reserved, errors.New(i18n.QueryI18n(i18n.ReservedLengthInvalid) + strconv.Itoa(len(values)))
+	}
+	for i, v := range values {
+		if v < 0 || v > 255 {
+			return [3]byte{}, errors.New(i18n.QueryI18n(i18n.ReservedByteInvalid) + strconv.Itoa(v))
+		}
+		reserved[i] = byte(v)
 	}
-	
-	// Copy to fixed-size array
-	reserved = [3]byte{tempSlice[0], tempSlice[1], tempSlice[2]}
 	return
 }
+
+func bytesToInts(b []byte) []int {
+	values := make([]int, len(b))
+	for i, v := range b {
+		values[i] = int(v)
+	}
+	return values
+}
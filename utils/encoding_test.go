@@ -24,6 +24,48 @@ func TestParseReservedString(t *testing.T) {
 			wantReserved:   [3]byte{1, 2, 3},
 			wantErr:        false,
 		},
+		{
+			name:           "base64 client_id",
+			reservedString: "PL2v",
+			wantReserved:   [3]byte{60, 189, 175},
+			wantErr:        false,
+		},
+		{
+			name:           "hex",
+			reservedString: "0x3cbdaf",
+			wantReserved:   [3]byte{60, 189, 175},
+			wantErr:        false,
+		},
+		{
+			name:           "comma separated decimals",
+			reservedString: "60, 189,175",
+			wantReserved:   [3]byte{60, 189, 175},
+			wantErr:        false,
+		},
+		{
+			name:           "byte out of range",
+			reservedString: "[1, 2, 256]",
+			wantReserved:   [3]byte{},
+			wantErr:        true,
+		},
+		{
+			name:           "decimal out of range",
+			reservedString: "1,2,-1",
+			wantReserved:   [3]byte{},
+			wantErr:        true,
+		},
+		{
+			name:           "short hex",
+			reservedString: "0x3cbd",
+			wantReserved:   [3]byte{},
+			wantErr:        true,
+		},
+		{
+			name:           "long client_id",
+			reservedString: "PL2vAA==",
+			wantReserved:   [3]byte{},
+			wantErr:        true,
+		},
 		{
 			name:           "invalid json",
 			reservedString: "invalid",
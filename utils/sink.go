@@ -0,0 +1,347 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/peanut996/CloudflareWarpSpeedTest/i18n"
+)
+
+const (
+	FormatCSV      = "csv"
+	FormatJSON     = "json"
+	FormatNDJSON   = "ndjson"
+	FormatMarkdown = "markdown"
+
+	// stdoutPath writes a sink to standard output instead of a file.
+	stdoutPath = "-"
+)
+
+// stdout is standard output as of start up, main redirects os.Stdout to
+// standard error when results are written to it.
+var stdout io.Writer = os.Stdout
+
+// OutputFormat forces the format of every output in Output. When empty
+// the format follows the file extension, CSV for anything unknown.
+var OutputFormat = ""
+
+// formatExtensions maps file extensions to output formats.
+var formatExtensions = map[string]string{
+	".csv":      FormatCSV,
+	".json":     FormatJSON,
+	".ndjson":   FormatNDJSON,
+	".jsonl":    FormatNDJSON,
+	".md":       FormatMarkdown,
+	".markdown": FormatMarkdown,
+}
+
+// ResultSink is a destination for scan results.
+type ResultSink interface {
+	// Record receives every endpoint as soon as its probes finish, before
+	// any filtering or tunnel check.
+	Record(data CloudflareIPData)
+	// Write receives the final results, filtered and sorted.
+	Write(data PingDelaySet) error
+	Close() error
+}
+
+// Sinks fans results out to several sinks.
+type Sinks []ResultSink
+
+func (s Sinks) Record(data CloudflareIPData) {
+	for _, sink := range s {
+		sink.Record(data)
+	}
+}
+
+func (s Sinks) Write(data PingDelaySet) error {
+	var errs []error
+	for _, sink := range s {
+		errs = append(errs, sink.Write(data))
+	}
+	return errors.Join(errs...)
+}
+
+func (s Sinks) Close() error {
+	var errs []error
+	for _, sink := range s {
+		errs = append(errs, sink.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// outputPaths splits the comma-separated Output into its paths.
+func outputPaths() []string {
+	var paths []string
+	for _, p := range strings.Split(Output, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// UsesStdout reports whether Output writes to standard output, in which
+// case everything else should go to standard error.
+func UsesStdout() bool {
+	for _, p := range outputPaths() {
+		if p == stdoutPath {
+			return true
+		}
+	}
+	return false
+}
+
+// outputFiles returns the files Output writes to.
+func outputFiles() []string {
+	var files []string
+	for _, p := range outputPaths() {
+		if p != stdoutPath {
+			files = append(files, p)
+		}
+	}
+	return files
+}
+
+// outputFormat returns the format path is written in.
+func outputFormat(path string) (string, error) {
+	if OutputFormat != "" {
+		switch f := strings.ToLower(OutputFormat); f {
+		case FormatCSV, FormatJSON, FormatNDJSON, FormatMarkdown:
+			return f, nil
+		case "jsonl":
+			return FormatNDJSON, nil
+		case "md":
+			return FormatMarkdown, nil
+		}
+		return "", errors.New(i18n.QueryTemplateI18n(i18n.OutputFormatInvalid, map[string]interface{}{"Format": OutputFormat}))
+	}
+	if f, ok := formatExtensions[strings.ToLower(filepath.Ext(path))]; ok {
+		return f, nil
+	}
+	return FormatCSV, nil
+}
+
+// OpenSinks opens a sink for every path in Output. Streaming sinks create
+// their file right away, the others only once there are results to write.
+func OpenSinks() (Sinks, error) {
+	var sinks Sinks
+	for _, path := range outputPaths() {
+		format, err := outputFormat(path)
+		if err != nil {
+			return nil, err
+		}
+		var sink ResultSink
+		switch format {
+		case FormatNDJSON:
+			sink, err = newNDJSONSink(path)
+		case FormatJSON:
+			sink = &fileSink{path: path, render: writeJSON}
+		case FormatMarkdown:
+			sink = &fileSink{path: path, render: writeMarkdown}
+		default:
+			sink = &fileSink{path: path, render: writeCSV}
+		}
+		if err != nil {
+			sinks.Close()
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+func createOutput(path string) (*os.File, error) {
+	fp, err := os.Create(path)
+	if err != nil {
+		return nil, errors.New(i18n.QueryTemplateI18n(i18n.CreateFileFailed, map[string]interface{}{"Output": path, "err": err}))
+	}
+	return fp, nil
+}
+
+// fileSink renders the final results to a file or standard output.
+type fileSink struct {
+	path   string
+	render func(w io.Writer, data PingDelaySet) error
+}
+
+func (s *fileSink) Record(CloudflareIPData) {}
+
+func (s *fileSink) Write(data PingDelaySet) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if s.path == stdoutPath {
+		return s.render(stdout, data)
+	}
+	fp, err := createOutput(s.path)
+	if err != nil {
+		return err
+	}
+	if err := s.render(fp, data); err != nil {
+		fp.Close()
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+	return fp.Close()
+}
+
+func (s *fileSink) Close() error {
+	return nil
+}
+
+// ndjsonSink streams one JSON object per endpoint as it is recorded.
+type ndjsonSink struct {
+	m   sync.Mutex
+	w   io.Writer
+	fp  *os.File
+	enc *json.Encoder
+	err error
+}
+
+func newNDJSONSink(path string) (*ndjsonSink, error) {
+	s := &ndjsonSink{w: stdout}
+	if path != stdoutPath {
+		fp, err := createOutput(path)
+		if err != nil {
+			return nil, err
+		}
+		s.w, s.fp = fp, fp
+	}
+	s.enc = json.NewEncoder(s.w)
+	return s, nil
+}
+
+func (s *ndjsonSink) Record(data CloudflareIPData) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.err == nil {
+		s.err = s.enc.Encode(newResultRecord(&data))
+	}
+}
+
+func (s *ndjsonSink) Write(PingDelaySet) error {
+	return nil
+}
+
+func (s *ndjsonSink) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	err := s.err
+	if s.fp != nil {
+		err = errors.Join(err, s.fp.Close())
+		s.fp = nil
+	}
+	return err
+}
+
+// resultRecord is the JSON form of CloudflareIPData, with times in
+// milliseconds and the loss rate as a fraction.
+type resultRecord struct {
+	Endpoint         string  `json:"endpoint"`
+	Sent             int     `json:"sent"`
+	Received         int     `json:"received"`
+	Loss             float64 `json:"loss"`
+	Latency          float64 `json:"latency_ms"`
+	Status           string  `json:"status"`
+	Min              float64 `json:"min_ms"`
+	Max              float64 `json:"max_ms"`
+	Median           float64 `json:"median_ms"`
+	P90              float64 `json:"p90_ms"`
+	P99              float64 `json:"p99_ms"`
+	StdDev           float64 `json:"stddev_ms"`
+	Jitter           float64 `json:"jitter_ms"`
+	DownloadMbps     float64 `json:"download_mbps"`
+	UploadMbps       float64 `json:"upload_mbps"`
+	CookieChallenged int     `json:"cookie_challenged"`
+	Throttled        int     `json:"throttled"`
+	LimiterWait      float64 `json:"limiter_wait_ms"`
+	DataPlane        string  `json:"data_plane"`
+	DataPlaneRTT     float64 `json:"data_plane_rtt_ms,omitempty"`
+	Family           string  `json:"family"`
+	Source           string  `json:"source,omitempty"`
+	Proxy            string  `json:"proxy,omitempty"`
+	Protocol         string  `json:"protocol,omitempty"`
+	ConnectTime      float64 `json:"connect_time_ms,omitempty"`
+	MaxLossRun       int     `json:"max_loss_run"`
+	LossBursts       int     `json:"loss_bursts"`
+	BurstRatio       float64 `json:"burst_ratio"`
+	ProbePattern     string  `json:"probe_pattern"`
+}
+
+func newResultRecord(cf *CloudflareIPData) resultRecord {
+	r := resultRecord{
+		Endpoint:         cf.IP.String(),
+		Sent:             cf.Sent,
+		Received:         cf.Received,
+		Loss:             math.Round(float64(cf.getLossRate())*1e4) / 1e4,
+		Latency:          milliseconds(cf.Delay),
+		Status:           cf.Status.String(),
+		Min:              milliseconds(cf.MinDelay),
+		Max:              milliseconds(cf.MaxDelay),
+		Median:           milliseconds(cf.MedianDelay),
+		P90:              milliseconds(cf.P90Delay),
+		P99:              milliseconds(cf.P99Delay),
+		StdDev:           milliseconds(cf.StdDev),
+		Jitter:           milliseconds(cf.Jitter),
+		DownloadMbps:     cf.DownloadSpeed,
+		UploadMbps:       cf.UploadSpeed,
+		CookieChallenged: cf.CookieChallenged,
+		Throttled:        cf.Throttled,
+		LimiterWait:      milliseconds(cf.LimiterWait),
+		DataPlane:        cf.DataPlane.String(),
+		Family:           cf.Family(),
+		Source:           cf.Source,
+		Proxy:            cf.Proxy,
+		Protocol:         cf.Protocol,
+		ConnectTime:      milliseconds(cf.ConnectDelay),
+		MaxLossRun:       cf.MaxLossRun,
+		LossBursts:       cf.LossBursts,
+		BurstRatio:       cf.BurstRatio,
+		ProbePattern:     formatOutcomes(cf.Outcomes),
+	}
+	if cf.DataPlane == DataPlaneOK {
+		r.DataPlaneRTT = milliseconds(cf.DataPlaneRTT)
+	}
+	return r
+}
+
+// milliseconds rounds d to the precision of the CSV output.
+func milliseconds(d time.Duration) float64 {
+	return math.Round(d.Seconds()*1e5) / 100
+}
+
+func writeJSON(w io.Writer, data PingDelaySet) error {
+	records := make([]resultRecord, len(data))
+	for i := range data {
+		records[i] = newResultRecord(&data[i])
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+func writeMarkdown(w io.Writer, data PingDelaySet) error {
+	var b strings.Builder
+	writeRow := func(cells []string) {
+		b.WriteString("|")
+		for _, c := range cells {
+			b.WriteString(" " + strings.ReplaceAll(c, "|", `\|`) + " |")
+		}
+		b.WriteString("\n")
+	}
+	writeRow(resultHeader)
+	b.WriteString(strings.Repeat("| --- ", len(resultHeader)) + "|\n")
+	for _, row := range convertToString(data) {
+		writeRow(row)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sinkTestData() PingDelaySet {
+	return PingDelaySet{{
+		PingData: &PingData{
+			IP:       &net.UDPAddr{IP: net.ParseIP("162.159.192.1"), Port: 2408},
+			Sent:     4,
+			Received: 3,
+			Delay:    12345 * time.Microsecond,
+			Outcomes: []bool{true, false, true, true},
+			Protocol: "wireguard",
+		},
+		DataPlane:    DataPlaneOK,
+		DataPlaneRTT: 20 * time.Millisecond,
+	}}
+}
+
+func TestOutputFormat(t *testing.T) {
+	origFormat := OutputFormat
+	defer func() { OutputFormat = origFormat }()
+
+	tests := []struct {
+		path   string
+		format string
+		want   string
+	}{
+		{"result.csv", "", FormatCSV},
+		{"result.JSON", "", FormatJSON},
+		{"result.ndjson", "", FormatNDJSON},
+		{"result.jsonl", "", FormatNDJSON},
+		{"result.md", "", FormatMarkdown},
+		{"result.txt", "", FormatCSV},
+		{"-", "", FormatCSV},
+		{"result.csv", "json", FormatJSON},
+		{"-", "jsonl", FormatNDJSON},
+		{"result.txt", "md", FormatMarkdown},
+	}
+	for _, tt := range tests {
+		OutputFormat = tt.format
+		if got, err := outputFormat(tt.path); err != nil || got != tt.want {
+			t.Errorf("outputFormat(%q) with -of %q = %q, %v, want %q", tt.path, tt.format, got, err, tt.want)
+		}
+	}
+
+	OutputFormat = "xml"
+	if _, err := outputFormat("result.csv"); err == nil {
+		t.Error("outputFormat() error = nil for an unknown format")
+	}
+}
+
+func TestOpenSinks(t *testing.T) {
+	origOutput, origFormat := Output, OutputFormat
+	defer func() { Output, OutputFormat = origOutput, origFormat }()
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "result.csv")
+	jsonPath := filepath.Join(dir, "result.json")
+	mdPath := filepath.Join(dir, "result.md")
+	ndjsonPath := filepath.Join(dir, "result.ndjson")
+	Output = strings.Join([]string{csvPath, jsonPath, " " + mdPath, ndjsonPath, ""}, ",")
+	OutputFormat = ""
+
+	sinks, err := OpenSinks()
+	if err != nil {
+		t.Fatalf("OpenSinks() error = %v", err)
+	}
+	if len(sinks) != 4 {
+		t.Fatalf("OpenSinks() opened %d sinks, want 4", len(sinks))
+	}
+	if _, err := os.Stat(ndjsonPath); err != nil {
+		t.Errorf("OpenSinks() did not create the streaming output: %v", err)
+	}
+	if _, err := os.Stat(csvPath); err == nil {
+		t.Error("OpenSinks() created a batch output before any result")
+	}
+
+	data := sinkTestData()
+	sinks.Record(data[0])
+	if err := sinks.Write(data); err != nil {
+		t.Fatalf("Sinks.Write() error = %v", err)
+	}
+	if err := sinks.Close(); err != nil {
+		t.Fatalf("Sinks.Close() error = %v", err)
+	}
+
+	read := func(path string) string {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	if got := read(csvPath); !strings.HasPrefix(got, "IP:Port,Loss,Latency,") || !strings.Contains(got, "\n162.159.192.1:2408,25%,12.35,ok,") {
+		t.Errorf("CSV output = %q", got)
+	}
+
+	var records []resultRecord
+	if err := json.Unmarshal([]byte(read(jsonPath)), &records); err != nil || len(records) != 1 {
+		t.Fatalf("JSON output = %v, %v, want one record", records, err)
+	}
+	want := resultRecord{
+		Endpoint:     "162.159.192.1:2408",
+		Sent:         4,
+		Received:     3,
+		Loss:         0.25,
+		Latency:      12.35,
+		Status:       "ok",
+		DataPlane:    "ok",
+		DataPlaneRTT: 20,
+		Family:       FamilyIPv4,
+		Protocol:     "wireguard",
+		ProbePattern: "+.++",
+	}
+	if records[0] != want {
+		t.Errorf("JSON output = %+v, want %+v", records[0], want)
+	}
+
+	lines := strings.Split(read(mdPath), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "| IP:Port | Loss |") || !strings.HasPrefix(lines[1], "| --- |") ||
+		!strings.HasPrefix(lines[2], "| 162.159.192.1:2408 | 25% |") {
+		t.Errorf("Markdown output = %q", lines)
+	}
+
+	var streamed resultRecord
+	if err := json.Unmarshal([]byte(read(ndjsonPath)), &streamed); err != nil || streamed != want {
+		t.Errorf("NDJSON output = %+v, %v, want %+v", streamed, err, want)
+	}
+}
+
+func TestOpenSinks_NoResults(t *testing.T) {
+	origOutput := Output
+	defer func() { Output = origOutput }()
+	path := filepath.Join(t.TempDir(), "result.csv")
+	Output = path
+
+	sinks, err := OpenSinks()
+	if err != nil {
+		t.Fatalf("OpenSinks() error = %v", err)
+	}
+	if err := sinks.Write(nil); err != nil {
+		t.Fatalf("Sinks.Write() error = %v", err)
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Error("Sinks.Write() created an output without results")
+	}
+}
+
+func TestNDJSONSink_Stdout(t *testing.T) {
+	origOutput, origFormat, origStdout := Output, OutputFormat, stdout
+	defer func() { Output, OutputFormat, stdout = origOutput, origFormat, origStdout }()
+	var buf bytes.Buffer
+	stdout = &buf
+	Output, OutputFormat = "-", "ndjson"
+
+	if !UsesStdout() {
+		t.Error("UsesStdout() = false for -o -")
+	}
+	if files := outputFiles(); len(files) != 0 {
+		t.Errorf("outputFiles() = %v, want none", files)
+	}
+	sinks, err := OpenSinks()
+	if err != nil {
+		t.Fatalf("OpenSinks() error = %v", err)
+	}
+	data := sinkTestData()
+	sinks.Record(data[0])
+	if got := strings.Count(buf.String(), "\n"); got != 1 {
+		t.Errorf("Record() wrote %d lines before Write(), want 1", got)
+	}
+	sinks.Record(data[0])
+	sinks.Write(data)
+	if err := sinks.Close(); err != nil {
+		t.Fatalf("Sinks.Close() error = %v", err)
+	}
+	if got := strings.Count(buf.String(), "\n"); got != 2 {
+		t.Errorf("NDJSON output has %d lines, want one per recorded endpoint", got)
+	}
+}
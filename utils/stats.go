@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// LatencyStats summarises the round-trip times of one endpoint.
+type LatencyStats struct {
+	MinDelay    time.Duration
+	MaxDelay    time.Duration
+	MedianDelay time.Duration
+	P90Delay    time.Duration
+	P99Delay    time.Duration
+	StdDev      time.Duration
+	// Jitter is the RFC 3550 interarrival jitter estimate over consecutive
+	// samples.
+	Jitter time.Duration
+}
+
+// NewLatencyStats computes LatencyStats from RTT samples in the order they
+// were measured.
+func NewLatencyStats(samples []time.Duration) LatencyStats {
+	var stats LatencyStats
+	if len(samples) == 0 {
+		return stats
+	}
+
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	stats.MinDelay = sorted[0]
+	stats.MaxDelay = sorted[len(sorted)-1]
+	stats.MedianDelay = percentile(sorted, 50)
+	stats.P90Delay = percentile(sorted, 90)
+	stats.P99Delay = percentile(sorted, 99)
+
+	var sum float64
+	for _, v := range samples {
+		sum += float64(v)
+	}
+	mean := sum / float64(len(samples))
+	var variance float64
+	for _, v := range samples {
+		variance += (float64(v) - mean) * (float64(v) - mean)
+	}
+	stats.StdDev = time.Duration(math.Sqrt(variance / float64(len(samples))))
+
+	var jitter float64
+	for i := 1; i < len(samples); i++ {
+		d := math.Abs(float64(samples[i] - samples[i-1]))
+		jitter += (d - jitter) / 16
+	}
+	stats.Jitter = time.Duration(jitter)
+	return stats
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted samples.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// LossStats describes how the lost probes of one endpoint are spread over
+// the probe sequence.
+type LossStats struct {
+	// MaxLossRun is the longest run of consecutive lost probes.
+	MaxLossRun int
+	// LossBursts counts runs of consecutive lost probes.
+	LossBursts int
+	// BurstRatio is the Gilbert–Elliott burst ratio 1/(p+r), where p is the
+	// probability of a loss after a reply and r of a reply after a loss. It
+	// is 1 for random loss, above 1 when losses cluster, below 1 when they
+	// alternate with replies, and 0 without loss.
+	BurstRatio float64
+}
+
+// NewLossStats computes LossStats from per-probe outcomes in probe order,
+// true meaning the probe was answered.
+func NewLossStats(outcomes []bool) LossStats {
+	var stats LossStats
+	var run, fromOK, toLoss, fromLoss, toOK int
+	for i, ok := range outcomes {
+		if ok {
+			run = 0
+		} else {
+			if run == 0 {
+				stats.LossBursts++
+			}
+			run++
+			stats.MaxLossRun = max(stats.MaxLossRun, run)
+		}
+		if i == 0 {
+			continue
+		}
+		if outcomes[i-1] {
+			fromOK++
+			if !ok {
+				toLoss++
+			}
+		} else {
+			fromLoss++
+			if ok {
+				toOK++
+			}
+		}
+	}
+	if stats.LossBursts == 0 {
+		return stats
+	}
+	var p, r float64
+	if fromOK > 0 {
+		p = float64(toLoss) / float64(fromOK)
+	}
+	if fromLoss > 0 {
+		r = float64(toOK) / float64(fromLoss)
+	}
+	if p+r > 0 {
+		stats.BurstRatio = 1 / (p + r)
+	}
+	return stats
+}
@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNewLatencyStats(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name    string
+		samples []time.Duration
+		want    LatencyStats
+	}{
+		{
+			name:    "no samples",
+			samples: nil,
+			want:    LatencyStats{},
+		},
+		{
+			name:    "single sample",
+			samples: []time.Duration{10 * ms},
+			want: LatencyStats{
+				MinDelay: 10 * ms, MaxDelay: 10 * ms, MedianDelay: 10 * ms,
+				P90Delay: 10 * ms, P99Delay: 10 * ms,
+			},
+		},
+		{
+			name:    "constant latency has no jitter",
+			samples: []time.Duration{20 * ms, 20 * ms, 20 * ms, 20 * ms},
+			want: LatencyStats{
+				MinDelay: 20 * ms, MaxDelay: 20 * ms, MedianDelay: 20 * ms,
+				P90Delay: 20 * ms, P99Delay: 20 * ms,
+			},
+		},
+		{
+			name:    "alternating latency",
+			samples: []time.Duration{10 * ms, 30 * ms},
+			want: LatencyStats{
+				MinDelay: 10 * ms, MaxDelay: 30 * ms, MedianDelay: 10 * ms,
+				P90Delay: 30 * ms, P99Delay: 30 * ms,
+				StdDev: 10 * ms, Jitter: 1250 * time.Microsecond,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewLatencyStats(tt.samples); got != tt.want {
+				t.Errorf("NewLatencyStats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := make([]time.Duration, 10)
+	for i := range sorted {
+		sorted[i] = time.Duration(i+1) * time.Millisecond
+	}
+
+	tests := []struct {
+		name string
+		p    float64
+		want time.Duration
+	}{
+		{name: "median", p: 50, want: 5 * time.Millisecond},
+		{name: "p90", p: 90, want: 9 * time.Millisecond},
+		{name: "p99", p: 99, want: 10 * time.Millisecond},
+		{name: "p0", p: 0, want: 1 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(sorted, tt.p); got != tt.want {
+				t.Errorf("percentile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewLossStats(t *testing.T) {
+	const o, x = true, false
+	tests := []struct {
+		name     string
+		outcomes []bool
+		want     LossStats
+	}{
+		{name: "no probes", want: LossStats{}},
+		{name: "no loss", outcomes: []bool{o, o, o, o}, want: LossStats{}},
+		// p = 2/3, r = 2/2
+		{name: "spread loss", outcomes: []bool{o, x, o, o, x, o}, want: LossStats{MaxLossRun: 1, LossBursts: 2, BurstRatio: 0.6}},
+		// p = 1/2, r = 1/3
+		{name: "outage", outcomes: []bool{o, o, x, x, x, o}, want: LossStats{MaxLossRun: 3, LossBursts: 1, BurstRatio: 1.2}},
+		{name: "trailing outage", outcomes: []bool{o, o, x, x}, want: LossStats{MaxLossRun: 2, LossBursts: 1, BurstRatio: 2}},
+		{name: "all lost", outcomes: []bool{x, x, x}, want: LossStats{MaxLossRun: 3, LossBursts: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewLossStats(tt.outcomes)
+			if got.MaxLossRun != tt.want.MaxLossRun || got.LossBursts != tt.want.LossBursts ||
+				math.Abs(got.BurstRatio-tt.want.BurstRatio) > 1e-9 {
+				t.Errorf("NewLossStats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package warp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"github.com/peanut996/CloudflareWarpSpeedTest/utils"
+)
+
+// DefaultAccountFile is where register saves the account when no file is
+// given.
+const DefaultAccountFile = "warp-account.json"
+
+// Account is a registered WARP device together with everything needed to
+// connect as it.
+type Account struct {
+	// ID and Token identify and authenticate the device to the API.
+	ID          string `json:"id"`
+	Token       string `json:"token"`
+	Name        string `json:"name,omitempty"`
+	AccountID   string `json:"account_id"`
+	AccountType string `json:"account_type"`
+	License     string `json:"license"`
+
+	PrivateKey    string `json:"private_key"`
+	PeerPublicKey string `json:"peer_public_key"`
+	// ClientID is the base64 form of Reserved.
+	ClientID string  `json:"client_id"`
+	Reserved [3]byte `json:"reserved"`
+	Endpoint string  `json:"endpoint"`
+	IPv4     string  `json:"ipv4"`
+	IPv6     string  `json:"ipv6"`
+}
+
+func (a *Account) path() string {
+	return "/reg/" + a.ID
+}
+
+// Refresh fetches the device profile and updates the account from it.
+func (a *Account) Refresh(ctx context.Context) error {
+	var p profile
+	if err := call(ctx, http.MethodGet, a.path(), a.Token, nil, &p); err != nil {
+		return err
+	}
+	return a.update(&p)
+}
+
+func (a *Account) update(p *profile) error {
+	a.Name = p.Name
+	a.AccountID = p.Account.ID
+	a.AccountType = p.Account.AccountType
+	a.License = p.Account.License
+	a.ClientID = p.Config.ClientID
+	reserved, err := utils.ParseReservedString(a.ClientID)
+	if err != nil {
+		return err
+	}
+	a.Reserved = reserved
+	if len(p.Config.Peers) > 0 {
+		a.PeerPublicKey = p.Config.Peers[0].PublicKey
+		a.Endpoint = p.Config.Peers[0].Endpoint.Host
+	}
+	a.IPv4 = p.Config.Interface.Addresses.V4
+	a.IPv6 = p.Config.Interface.Addresses.V6
+	return nil
+}
+
+// LoadAccount reads an account saved by Save.
+func LoadAccount(path string) (*Account, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	acc := &Account{}
+	if err := json.Unmarshal(data, acc); err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// Save writes the account to path, readable by the owner only as it holds
+// the private key and API token.
+func (a *Account) Save(path string) error {
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// Create saves the account like Save, but fails with fs.ErrExist rather
+// than replace an existing file, whose device could no longer be managed.
+func (a *Account) Create(path string) error {
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Quota is the data allowance of the account a device belongs to, in
+// bytes.
+type Quota struct {
+	AccountType string `json:"account_type"`
+	WarpPlus    bool   `json:"warp_plus"`
+	// PremiumData is the WARP+ data left out of Quota.
+	PremiumData int64  `json:"premium_data"`
+	Quota       int64  `json:"quota"`
+	License     string `json:"license"`
+}
+
+// Quota fetches the account type and data allowance.
+func (a *Account) Quota(ctx context.Context) (*Quota, error) {
+	q := &Quota{}
+	if err := call(ctx, http.MethodGet, a.path()+"/account", a.Token, nil, q); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// ApplyLicense moves the device to the account of a WARP+ license key.
+func (a *Account) ApplyLicense(ctx context.Context, license string) error {
+	if err := call(ctx, http.MethodPut, a.path()+"/account", a.Token, map[string]string{"license": license}, nil); err != nil {
+		return err
+	}
+	return a.Refresh(ctx)
+}
+
+// Rename sets the device name shown in the account's device list.
+func (a *Account) Rename(ctx context.Context, name string) error {
+	if err := call(ctx, http.MethodPatch, a.path(), a.Token, map[string]string{"name": name}, nil); err != nil {
+		return err
+	}
+	return a.Refresh(ctx)
+}
+
+// RotateKey registers a new key pair for the device and keeps its private
+// key. The new key is kept even if refreshing the account afterwards fails,
+// as the API no longer accepts the old one.
+func (a *Account) RotateKey(ctx context.Context) error {
+	privateKey, publicKey, err := newKeyPair()
+	if err != nil {
+		return err
+	}
+	if err := call(ctx, http.MethodPatch, a.path(), a.Token, map[string]string{"key": publicKey}, nil); err != nil {
+		return err
+	}
+	a.PrivateKey = privateKey
+	return a.Refresh(ctx)
+}
+
+// Delete removes the device registration. The account cannot be used
+// afterwards.
+func (a *Account) Delete(ctx context.Context) error {
+	return call(ctx, http.MethodDelete, a.path(), a.Token, nil, nil)
+}
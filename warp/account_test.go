@@ -0,0 +1,147 @@
+package warp
+
+import (
+	"context"
+	"crypto/ecdh"
+	"encoding/base64"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAccount_SaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DefaultAccountFile)
+	acc := &Account{
+		ID:            "dev-1",
+		Token:         "token-1",
+		PrivateKey:    "cGcJ2hcPvT3XXA8fCvOAnA9W5pDqPWgYgO2SXYUTwXE=",
+		PeerPublicKey: "bmXOC+F1FxEMF9dyiK2H5/1SUtzH0JuVo51h2wPfgyo=",
+		ClientID:      "PL2v",
+		Reserved:      [3]byte{60, 189, 175},
+		IPv4:          "172.16.0.2",
+	}
+	if err := acc.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("Save() mode = %v, want 0600", perm)
+	}
+
+	got, err := LoadAccount(path)
+	if err != nil {
+		t.Fatalf("LoadAccount() error = %v", err)
+	}
+	if *got != *acc {
+		t.Errorf("LoadAccount() = %+v, want %+v", *got, *acc)
+	}
+}
+
+func TestAccount_Create(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DefaultAccountFile)
+	first := &Account{ID: "dev-1", Token: "token-1"}
+	if err := first.Create(path); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Create() file = %v, %v, want mode 0600", info, err)
+	}
+
+	second := &Account{ID: "dev-2", Token: "token-2"}
+	if err := second.Create(path); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Create() over an existing file error = %v, want fs.ErrExist", err)
+	}
+	got, err := LoadAccount(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *first {
+		t.Errorf("LoadAccount() = %+v, want the first account %+v", *got, *first)
+	}
+}
+
+func TestLoadAccount_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.json")
+	os.WriteFile(path, []byte("{"), 0o600)
+	if _, err := LoadAccount(path); err == nil {
+		t.Error("LoadAccount() error = nil for a truncated file")
+	}
+	if _, err := LoadAccount(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("LoadAccount() error = nil for a missing file")
+	}
+}
+
+func TestAccount_Manage(t *testing.T) {
+	api := startMockAPI(t)
+	ctx := context.Background()
+	acc, err := Register(ctx)
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	q, err := acc.Quota(ctx)
+	if err != nil || q.AccountType != "free" || q.WarpPlus {
+		t.Errorf("Quota() = %+v, %v, want a free account", q, err)
+	}
+
+	if err := acc.ApplyLicense(ctx, "lic-2"); err != nil {
+		t.Fatalf("ApplyLicense() error = %v", err)
+	}
+	if acc.License != "lic-2" || acc.AccountType != "limited" {
+		t.Errorf("ApplyLicense() account = %s %s, want lic-2 limited", acc.License, acc.AccountType)
+	}
+	if q, err = acc.Quota(ctx); err != nil || !q.WarpPlus || q.PremiumData != 1000 || q.Quota != 4000 {
+		t.Errorf("Quota() = %+v, %v, want a WARP+ allowance", q, err)
+	}
+	if err := acc.ApplyLicense(ctx, ""); err == nil {
+		t.Error("ApplyLicense() error = nil for a rejected license")
+	}
+
+	if err := acc.Rename(ctx, "scanner"); err != nil || acc.Name != "scanner" {
+		t.Errorf("Rename() name = %q, %v, want scanner", acc.Name, err)
+	}
+
+	oldKey := acc.PrivateKey
+	if err := acc.RotateKey(ctx); err != nil {
+		t.Fatalf("RotateKey() error = %v", err)
+	}
+	if acc.PrivateKey == oldKey {
+		t.Error("RotateKey() kept the old private key")
+	}
+	pri, _ := base64.StdEncoding.DecodeString(acc.PrivateKey)
+	key, err := ecdh.X25519().NewPrivateKey(pri)
+	if err != nil || base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()) != api.key {
+		t.Error("RotateKey() private key does not match the registered public key")
+	}
+
+	if err := acc.Delete(ctx); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := acc.Refresh(ctx); err == nil {
+		t.Error("Refresh() error = nil after Delete()")
+	}
+}
+
+func TestAccount_RotateKey_RefreshFails(t *testing.T) {
+	api := startMockAPI(t)
+	ctx := context.Background()
+	acc, err := Register(ctx)
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	oldKey := acc.PrivateKey
+	api.m.Lock()
+	api.failGet = true
+	api.m.Unlock()
+	if err := acc.RotateKey(ctx); err == nil {
+		t.Fatal("RotateKey() error = nil when the refresh fails")
+	}
+	if acc.PrivateKey == oldKey {
+		t.Error("RotateKey() dropped the new private key when the refresh failed")
+	}
+}
@@ -0,0 +1,145 @@
+// Package warp talks to the WARP client API to create and manage the
+// accounts whose keys and reserved bytes the scanner uses.
+package warp
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultAPIBase = "https://api.cloudflareclient.com/v0i1909051800"
+
+	userAgent = "okhttp/3.12.1"
+	// maxErrorBody bounds how much of a failed response ends up in the error
+	maxErrorBody = 512
+)
+
+var (
+	// APIBase is the URL every API path is appended to.
+	APIBase = DefaultAPIBase
+
+	httpClient = &http.Client{Timeout: 30 * time.Second}
+)
+
+// profile is the device registration as returned by the API.
+type profile struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Token       string `json:"token"`
+	WarpEnabled bool   `json:"warp_enabled"`
+	Account     struct {
+		ID          string `json:"id"`
+		AccountType string `json:"account_type"`
+		License     string `json:"license"`
+	} `json:"account"`
+	Config struct {
+		ClientID string `json:"client_id"`
+		Peers    []struct {
+			PublicKey string `json:"public_key"`
+			Endpoint  struct {
+				Host string `json:"host"`
+			} `json:"endpoint"`
+		} `json:"peers"`
+		Interface struct {
+			Addresses struct {
+				V4 string `json:"v4"`
+				V6 string `json:"v6"`
+			} `json:"addresses"`
+		} `json:"interface"`
+	} `json:"config"`
+}
+
+// Register creates a key pair, registers it as a new device, enables WARP
+// on it and returns the resulting account. Once the device is registered,
+// the account is returned even along with an error, as its token is needed
+// to delete the registration.
+func Register(ctx context.Context) (*Account, error) {
+	privateKey, publicKey, err := newKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	var reg profile
+	err = call(ctx, http.MethodPost, "/reg", "", map[string]string{
+		"install_id": "",
+		"fcm_token":  "",
+		"tos":        time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+		"key":        publicKey,
+		"type":       "ios",
+		"locale":     "en_US",
+	}, &reg)
+	if err != nil {
+		return nil, err
+	}
+	if reg.ID == "" || reg.Token == "" {
+		return nil, fmt.Errorf("registration response lacks the device id or token")
+	}
+	acc := &Account{
+		ID:         reg.ID,
+		Token:      reg.Token,
+		PrivateKey: privateKey,
+	}
+	if err := call(ctx, http.MethodPatch, acc.path(), acc.Token, map[string]bool{"warp_enabled": true}, nil); err != nil {
+		return acc, err
+	}
+	if err := acc.Refresh(ctx); err != nil {
+		return acc, err
+	}
+	return acc, nil
+}
+
+// newKeyPair returns a Curve25519 key pair in base64.
+func newKeyPair() (privateKey, publicKey string, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(key.Bytes()), base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// call sends body as JSON to APIBase+path and decodes the response into
+// out, if not nil. token is sent as a bearer token when set.
+func call(ctx context.Context, method, path, token string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(APIBase, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package warp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	mockToken    = "token-1"
+	mockClientID = "PL2v"
+	mockPeerKey  = "bmXOC+F1FxEMF9dyiK2H5/1SUtzH0JuVo51h2wPfgyo="
+)
+
+// mockAPI serves the registration calls of the WARP client API for one
+// device.
+type mockAPI struct {
+	m       sync.Mutex
+	key     string
+	name    string
+	enabled bool
+	license string
+	deleted bool
+	// failPatch and failGet make updating and fetching the device profile
+	// fail
+	failPatch bool
+	failGet   bool
+	calls     []string
+}
+
+func startMockAPI(t *testing.T) *mockAPI {
+	api := &mockAPI{}
+	srv := httptest.NewServer(api)
+	origBase := APIBase
+	APIBase = srv.URL + "/v0a/"
+	t.Cleanup(func() {
+		APIBase = origBase
+		srv.Close()
+	})
+	return api
+}
+
+func (api *mockAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.m.Lock()
+	defer api.m.Unlock()
+	api.calls = append(api.calls, r.Method+" "+r.URL.Path)
+	if r.Header.Get("User-Agent") != userAgent {
+		http.Error(w, "unexpected user agent", http.StatusBadRequest)
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v0a/reg":
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body["tos"] == "" {
+			http.Error(w, "bad registration", http.StatusBadRequest)
+			return
+		}
+		api.key = body["key"]
+		api.writeProfile(w, true)
+	case api.deleted || !strings.HasPrefix(r.URL.Path, "/v0a/reg/dev-1"):
+		http.NotFound(w, r)
+	case r.Header.Get("Authorization") != "Bearer "+mockToken:
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	case r.URL.Path == "/v0a/reg/dev-1/account":
+		api.serveAccount(w, r)
+	case r.URL.Path != "/v0a/reg/dev-1":
+		http.NotFound(w, r)
+	case r.Method == http.MethodPatch && api.failPatch:
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	case r.Method == http.MethodPatch:
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		if enabled, ok := body["warp_enabled"].(bool); ok {
+			api.enabled = enabled
+		}
+		if name, ok := body["name"].(string); ok {
+			api.name = name
+		}
+		if key, ok := body["key"].(string); ok {
+			api.key = key
+		}
+		api.writeProfile(w, false)
+	case r.Method == http.MethodGet && api.failGet:
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	case r.Method == http.MethodGet:
+		api.writeProfile(w, false)
+	case r.Method == http.MethodDelete:
+		api.deleted = true
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (api *mockAPI) serveAccount(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut:
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["license"] == "" {
+			http.Error(w, "invalid license", http.StatusBadRequest)
+			return
+		}
+		api.license = body["license"]
+	case http.MethodGet:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"account_type": api.accountType(),
+		"warp_plus":    api.license != "",
+		"premium_data": 1000,
+		"quota":        4000,
+		"license":      api.license,
+	})
+}
+
+func (api *mockAPI) accountType() string {
+	if api.license != "" {
+		return "limited"
+	}
+	return "free"
+}
+
+func (api *mockAPI) writeProfile(w http.ResponseWriter, withToken bool) {
+	p := map[string]any{
+		"id":           "dev-1",
+		"key":          api.key,
+		"name":         api.name,
+		"warp_enabled": api.enabled,
+		"account":      map[string]any{"id": "acc-1", "account_type": api.accountType(), "license": api.license},
+		"config": map[string]any{
+			"client_id": mockClientID,
+			"peers": []any{map[string]any{
+				"public_key": mockPeerKey,
+				"endpoint":   map[string]any{"host": "engage.cloudflareclient.com:2408"},
+			}},
+			"interface": map[string]any{"addresses": map[string]any{
+				"v4": "172.16.0.2",
+				"v6": "2606:4700:110:8a36::1",
+			}},
+		},
+	}
+	if withToken {
+		p["token"] = mockToken
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+func TestRegister(t *testing.T) {
+	api := startMockAPI(t)
+
+	acc, err := Register(context.Background())
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	want := Account{
+		ID:            "dev-1",
+		Token:         mockToken,
+		AccountID:     "acc-1",
+		AccountType:   "free",
+		PrivateKey:    acc.PrivateKey,
+		PeerPublicKey: mockPeerKey,
+		ClientID:      mockClientID,
+		Reserved:      [3]byte{60, 189, 175},
+		Endpoint:      "engage.cloudflareclient.com:2408",
+		IPv4:          "172.16.0.2",
+		IPv6:          "2606:4700:110:8a36::1",
+	}
+	if *acc != want {
+		t.Errorf("Register() = %+v, want %+v", *acc, want)
+	}
+	if key, err := base64.StdEncoding.DecodeString(acc.PrivateKey); err != nil || len(key) != 32 {
+		t.Errorf("Register() private key = %q, want 32 bytes of base64", acc.PrivateKey)
+	}
+	if !api.enabled {
+		t.Error("Register() did not enable WARP")
+	}
+	wantCalls := "POST /v0a/reg,PATCH /v0a/reg/dev-1,GET /v0a/reg/dev-1"
+	if got := strings.Join(api.calls, ","); got != wantCalls {
+		t.Errorf("Register() calls = %s, want %s", got, wantCalls)
+	}
+}
+
+func TestRegister_APIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "too many registrations", http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	origBase := APIBase
+	defer func() { APIBase = origBase }()
+	APIBase = srv.URL
+
+	_, err := Register(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "429") || !strings.Contains(err.Error(), "too many registrations") {
+		t.Errorf("Register() error = %v, want the status and body", err)
+	}
+}
+
+func TestRegister_Incomplete(t *testing.T) {
+	tests := []struct {
+		name      string
+		failPatch bool
+		failGet   bool
+	}{
+		{name: "enable fails", failPatch: true},
+		{name: "refresh fails", failGet: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := startMockAPI(t)
+			api.failPatch, api.failGet = tt.failPatch, tt.failGet
+
+			acc, err := Register(context.Background())
+			if err == nil {
+				t.Fatal("Register() error = nil, want the failed request")
+			}
+			if acc == nil || acc.ID != "dev-1" || acc.Token != mockToken || acc.PrivateKey == "" {
+				t.Fatalf("Register() = %+v, want the registered device and its keys", acc)
+			}
+			if err := acc.Delete(context.Background()); err != nil || !api.deleted {
+				t.Errorf("Delete() error = %v, deleted = %v", err, api.deleted)
+			}
+		})
+	}
+}